@@ -1,10 +1,15 @@
-package main
+package alonso
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
 // AST Node interface
 type Node interface {
 	String() string
+	Pos() Position
 }
 
 type Statement interface {
@@ -22,6 +27,13 @@ type Program struct {
 	Statements []Statement
 }
 
+func (p *Program) Pos() Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return Position{}
+}
+
 func (p *Program) String() string {
 	result := ""
 	for _, stmt := range p.Statements {
@@ -31,22 +43,26 @@ func (p *Program) String() string {
 }
 
 type GridStatement struct { // var declaration
+	Token Token
 	Name  *Identifier
 	Value Expression
 }
 
 func (gs *GridStatement) statementNode() {}
+func (gs *GridStatement) Pos() Position  { return gs.Token.Pos() }
 func (gs *GridStatement) String() string {
 	return fmt.Sprintf("grid %s = %s;", gs.Name.String(), gs.Value.String())
 }
 
 type PaceStatement struct { // function declaration
+	Token      Token
 	Name       *Identifier
 	Parameters []*Identifier
 	Body       *BlockStatement
 }
 
 func (ps *PaceStatement) statementNode() {}
+func (ps *PaceStatement) Pos() Position  { return ps.Token.Pos() }
 func (ps *PaceStatement) String() string {
 	params := ""
 	for i, p := range ps.Parameters {
@@ -58,22 +74,135 @@ func (ps *PaceStatement) String() string {
 	return fmt.Sprintf("pace %s(%s) %s", ps.Name.String(), params, ps.Body.String())
 }
 
+// PaceLiteral is an anonymous function expression. Name is filled in when
+// the literal is bound directly with grid or assignment.
+type PaceLiteral struct {
+	Token      Token
+	Name       string
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (pl *PaceLiteral) expressionNode() {}
+func (pl *PaceLiteral) Pos() Position   { return pl.Token.Pos() }
+func (pl *PaceLiteral) String() string {
+	params := ""
+	for i, p := range pl.Parameters {
+		if i > 0 {
+			params += ", "
+		}
+		params += p.String()
+	}
+	return fmt.Sprintf("pace(%s) %s", params, pl.Body.String())
+}
+
+// functionName is the name a pace literal reports in tracebacks.
+func functionName(lit *PaceLiteral) string {
+	if lit.Name == "" {
+		return "<anonymous>"
+	}
+	return lit.Name
+}
+
+type GarageStatement struct { // struct declaration
+	Token  Token
+	Name   *Identifier
+	Fields []*Identifier
+}
+
+func (gs *GarageStatement) statementNode() {}
+func (gs *GarageStatement) Pos() Position  { return gs.Token.Pos() }
+func (gs *GarageStatement) String() string {
+	fields := ""
+	for i, f := range gs.Fields {
+		if i > 0 {
+			fields += ", "
+		}
+		fields += f.String()
+	}
+	return fmt.Sprintf("garage %s { %s }", gs.Name.String(), fields)
+}
+
+type ImportStatement struct { // module import
+	Token Token
+	Path  string
+	Alias *Identifier
+}
+
+func (is *ImportStatement) statementNode() {}
+func (is *ImportStatement) Pos() Position  { return is.Token.Pos() }
+func (is *ImportStatement) String() string {
+	return fmt.Sprintf("import \"%s\" as %s", is.Path, is.Alias.String())
+}
+
 type CircuitStatement struct { // if statement
-	Condition   Expression
-	Consequence *BlockStatement
-	Alternative *BlockStatement
+	Token        Token
+	Condition    Expression
+	Consequence  *BlockStatement
+	ElseCircuits []*ElseCircuit // tried in order when Condition is false
+	Alternative  *BlockStatement
 }
 
 func (cs *CircuitStatement) statementNode() {}
+func (cs *CircuitStatement) Pos() Position  { return cs.Token.Pos() }
 func (cs *CircuitStatement) String() string {
 	result := fmt.Sprintf("circuit (%s) %s", cs.Condition.String(), cs.Consequence.String())
+	for _, branch := range cs.ElseCircuits {
+		result += fmt.Sprintf(" else_circuit circuit (%s) %s", branch.Condition.String(), branch.Consequence.String())
+	}
 	if cs.Alternative != nil {
 		result += fmt.Sprintf(" else_circuit %s", cs.Alternative.String())
 	}
 	return result
 }
 
+// ElseCircuit is an `else_circuit circuit (...) { ... }` branch of a
+// CircuitStatement.
+type ElseCircuit struct {
+	Token       Token // the circuit token
+	Condition   Expression
+	Consequence *BlockStatement
+}
+
+// StrategyStatement runs the first case with a value equal to Value, or a
+// range holding it, and Default when no case matches.
+type StrategyStatement struct { // switch statement
+	Token   Token
+	Value   Expression
+	Cases   []*StrategyCase
+	Default *BlockStatement
+}
+
+func (ss *StrategyStatement) statementNode() {}
+func (ss *StrategyStatement) Pos() Position  { return ss.Token.Pos() }
+func (ss *StrategyStatement) String() string {
+	result := fmt.Sprintf("strategy (%s) {", ss.Value.String())
+	for _, c := range ss.Cases {
+		values := make([]string, len(c.Values))
+		for idx, value := range c.Values {
+			values[idx] = value.String()
+		}
+		result += fmt.Sprintf("case %s: %s", strings.Join(values, ", "), c.Body.String())
+	}
+	if ss.Default != nil {
+		result += fmt.Sprintf("default: %s", ss.Default.String())
+	}
+	return result + "}"
+}
+
+// StrategyCase is one `case a, b..c:` of a StrategyStatement. Its Body
+// holds the statements up to the next case.
+type StrategyCase struct {
+	Token  Token // the case token
+	Values []Expression
+	Body   *BlockStatement
+}
+
+// LoopStatement is a three-clause loop. Any of Init, Condition and Update
+// may be nil; without a Condition the loop runs until break_flag.
 type LoopStatement struct { // for loop
+	Token     Token
+	Label     *Identifier // nil unless the loop is labelled
 	Init      Statement
 	Condition Expression
 	Update    Statement
@@ -81,26 +210,90 @@ type LoopStatement struct { // for loop
 }
 
 func (ls *LoopStatement) statementNode() {}
+func (ls *LoopStatement) Pos() Position  { return ls.Token.Pos() }
 func (ls *LoopStatement) String() string {
-	return fmt.Sprintf("loop (%s; %s; %s) %s",
-		ls.Init.String(), ls.Condition.String(), ls.Update.String(), ls.Body.String())
+	clauses := []string{"", "", ""}
+	for idx, clause := range []Node{ls.Init, ls.Condition, ls.Update} {
+		if clause != nil {
+			clauses[idx] = clause.String()
+		}
+	}
+	return fmt.Sprintf("%sloop (%s; %s; %s) %s", labelPrefix(ls.Label), clauses[0], clauses[1], clauses[2], ls.Body.String())
+}
+
+// labelPrefix shows the label of a loop, if it has one.
+func labelPrefix(label *Identifier) string {
+	if label == nil {
+		return ""
+	}
+	return label.String() + ": "
+}
+
+// labelSuffix shows the label a break_flag or continue_race names, if any.
+func labelSuffix(label *Identifier) string {
+	if label == nil {
+		return ""
+	}
+	return " " + label.String()
+}
+
+// LoopInStatement runs Body once for each element of Iterable, bound to
+// Value. Key, when given, is bound to the element's index, or to its key
+// in a map.
+type LoopInStatement struct { // for-in loop
+	Token    Token
+	Label    *Identifier
+	Key      *Identifier
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (ls *LoopInStatement) statementNode() {}
+func (ls *LoopInStatement) Pos() Position  { return ls.Token.Pos() }
+func (ls *LoopInStatement) String() string {
+	names := ls.Value.String()
+	if ls.Key != nil {
+		names = ls.Key.String() + ", " + names
+	}
+	return fmt.Sprintf("%sloop (%s in %s) %s", labelPrefix(ls.Label), names, ls.Iterable.String(), ls.Body.String())
+}
+
+// RangeExpression is the integers from Start up to and including End. It
+// can only appear as the Iterable of a LoopInStatement, or as a value of a
+// StrategyCase, where it holds any number between its bounds.
+type RangeExpression struct {
+	Token Token // the .. token
+	Start Expression
+	End   Expression
+}
+
+func (re *RangeExpression) expressionNode() {}
+func (re *RangeExpression) Pos() Position   { return re.Start.Pos() }
+func (re *RangeExpression) String() string {
+	return fmt.Sprintf("%s..%s", re.Start.String(), re.End.String())
 }
 
 type WhileRacingStatement struct { // while loop
+	Token     Token
+	Label     *Identifier
 	Condition Expression
 	Body      *BlockStatement
 }
 
 func (ws *WhileRacingStatement) statementNode() {}
+func (ws *WhileRacingStatement) Pos() Position  { return ws.Token.Pos() }
 func (ws *WhileRacingStatement) String() string {
-	return fmt.Sprintf("while_racing (%s) %s", ws.Condition.String(), ws.Body.String())
+	return fmt.Sprintf("%swhile_racing (%s) %s", labelPrefix(ws.Label), ws.Condition.String(), ws.Body.String())
 }
 
 type ReturnPitStatement struct { // return statement
+	Token Token
 	Value Expression
 }
 
 func (rs *ReturnPitStatement) statementNode() {}
+func (rs *ReturnPitStatement) Pos() Position  { return rs.Token.Pos() }
 func (rs *ReturnPitStatement) String() string {
 	if rs.Value != nil {
 		return fmt.Sprintf("return_pit %s;", rs.Value.String())
@@ -108,34 +301,78 @@ func (rs *ReturnPitStatement) String() string {
 	return "return_pit;"
 }
 
-type BreakFlagStatement struct{} // break statement
+// BreakFlagStatement leaves the loop named by Label, or the innermost
+// loop when Label is nil.
+type BreakFlagStatement struct { // break statement
+	Token Token
+	Label *Identifier
+}
 
 func (bs *BreakFlagStatement) statementNode() {}
+func (bs *BreakFlagStatement) Pos() Position  { return bs.Token.Pos() }
 func (bs *BreakFlagStatement) String() string {
-	return "break_flag;"
+	return "break_flag" + labelSuffix(bs.Label) + ";"
 }
 
-type ContinueRaceStatement struct{} // continue statement
+// SafetyCarStatement runs Body and, if it raises a runtime error, binds the
+// error to Param (when given) and runs Handler instead.
+type SafetyCarStatement struct { // try/catch statement
+	Token   Token
+	Body    *BlockStatement
+	Param   *Identifier
+	Handler *BlockStatement
+}
+
+func (ss *SafetyCarStatement) statementNode() {}
+func (ss *SafetyCarStatement) Pos() Position  { return ss.Token.Pos() }
+func (ss *SafetyCarStatement) String() string {
+	if ss.Param == nil {
+		return fmt.Sprintf("safety_car %s recover %s", ss.Body.String(), ss.Handler.String())
+	}
+	return fmt.Sprintf("safety_car %s recover (%s) %s", ss.Body.String(), ss.Param.String(), ss.Handler.String())
+}
+
+// ContinueRaceStatement starts the next iteration of the loop named by
+// Label, or of the innermost loop when Label is nil.
+type ContinueRaceStatement struct { // continue statement
+	Token Token
+	Label *Identifier
+}
 
 func (cs *ContinueRaceStatement) statementNode() {}
+func (cs *ContinueRaceStatement) Pos() Position  { return cs.Token.Pos() }
 func (cs *ContinueRaceStatement) String() string {
-	return "continue_race;"
+	return "continue_race" + labelSuffix(cs.Label) + ";"
 }
 
 type ExpressionStatement struct {
+	Token      Token
 	Expression Expression
 }
 
 func (es *ExpressionStatement) statementNode() {}
+func (es *ExpressionStatement) Pos() Position  { return es.Token.Pos() }
 func (es *ExpressionStatement) String() string {
 	return es.Expression.String() + ";"
 }
 
+// BadStatement stands in for a statement that did not parse, so that a
+// program with syntax errors still has a complete tree.
+type BadStatement struct {
+	Token Token // where the statement began
+}
+
+func (bs *BadStatement) statementNode() {}
+func (bs *BadStatement) Pos() Position  { return bs.Token.Pos() }
+func (bs *BadStatement) String() string { return "<bad statement>;" }
+
 type BlockStatement struct {
+	Token      Token
 	Statements []Statement
 }
 
 func (bs *BlockStatement) statementNode() {}
+func (bs *BlockStatement) Pos() Position  { return bs.Token.Pos() }
 func (bs *BlockStatement) String() string {
 	result := "{"
 	for _, stmt := range bs.Statements {
@@ -147,37 +384,80 @@ func (bs *BlockStatement) String() string {
 
 // Expressions
 type Identifier struct {
+	Token Token
 	Value string
 }
 
 func (i *Identifier) expressionNode() {}
+func (i *Identifier) Pos() Position   { return i.Token.Pos() }
 func (i *Identifier) String() string {
 	return i.Value
 }
 
+type IntegerLiteral struct {
+	Token Token
+	Value int64
+}
+
+func (il *IntegerLiteral) expressionNode() {}
+func (il *IntegerLiteral) Pos() Position   { return il.Token.Pos() }
+func (il *IntegerLiteral) String() string {
+	return strconv.FormatInt(il.Value, 10)
+}
+
+// NumberLiteral is a floating point literal.
 type NumberLiteral struct {
+	Token Token
 	Value float64
 }
 
 func (nl *NumberLiteral) expressionNode() {}
+func (nl *NumberLiteral) Pos() Position   { return nl.Token.Pos() }
 func (nl *NumberLiteral) String() string {
 	return fmt.Sprintf("%g", nl.Value)
 }
 
 type StringLiteral struct {
+	Token Token
 	Value string
 }
 
 func (sl *StringLiteral) expressionNode() {}
+func (sl *StringLiteral) Pos() Position   { return sl.Token.Pos() }
 func (sl *StringLiteral) String() string {
 	return fmt.Sprintf("\"%s\"", sl.Value)
 }
 
+// InterpolatedString is a string literal containing ${...}. Its Parts are
+// the literal text, as *StringLiteral, and the embedded expressions in order.
+type InterpolatedString struct {
+	Token Token
+	Parts []Expression
+}
+
+func (is *InterpolatedString) expressionNode() {}
+func (is *InterpolatedString) Pos() Position   { return is.Token.Pos() }
+func (is *InterpolatedString) String() string {
+	var out strings.Builder
+	out.WriteString("\"")
+	for _, part := range is.Parts {
+		if text, ok := part.(*StringLiteral); ok {
+			out.WriteString(text.Value)
+		} else {
+			out.WriteString("${" + part.String() + "}")
+		}
+	}
+	out.WriteString("\"")
+	return out.String()
+}
+
 type BooleanLiteral struct {
+	Token Token
 	Value bool
 }
 
 func (bl *BooleanLiteral) expressionNode() {}
+func (bl *BooleanLiteral) Pos() Position   { return bl.Token.Pos() }
 func (bl *BooleanLiteral) String() string {
 	if bl.Value {
 		return "true"
@@ -186,10 +466,12 @@ func (bl *BooleanLiteral) String() string {
 }
 
 type FormationLiteral struct { // array literal
+	Token    Token
 	Elements []Expression
 }
 
 func (fl *FormationLiteral) expressionNode() {}
+func (fl *FormationLiteral) Pos() Position   { return fl.Token.Pos() }
 func (fl *FormationLiteral) String() string {
 	result := "["
 	for i, elem := range fl.Elements {
@@ -202,43 +484,108 @@ func (fl *FormationLiteral) String() string {
 	return result
 }
 
+type MapPair struct {
+	Key   Expression
+	Value Expression
+}
+
+type MapLiteral struct { // hash map literal
+	Token Token
+	Pairs []MapPair
+}
+
+func (ml *MapLiteral) expressionNode() {}
+func (ml *MapLiteral) Pos() Position   { return ml.Token.Pos() }
+func (ml *MapLiteral) String() string {
+	result := "{"
+	for i, pair := range ml.Pairs {
+		if i > 0 {
+			result += ", "
+		}
+		result += pair.Key.String() + ": " + pair.Value.String()
+	}
+	result += "}"
+	return result
+}
+
 type IndexExpression struct {
+	Token Token
 	Left  Expression
 	Index Expression
 }
 
 func (ie *IndexExpression) expressionNode() {}
+func (ie *IndexExpression) Pos() Position   { return ie.Token.Pos() }
 func (ie *IndexExpression) String() string {
 	return fmt.Sprintf("(%s[%s])", ie.Left.String(), ie.Index.String())
 }
 
+// SliceExpression is left[start:end]. Start and End are nil when omitted.
+type SliceExpression struct {
+	Token Token
+	Left  Expression
+	Start Expression
+	End   Expression
+}
+
+func (se *SliceExpression) expressionNode() {}
+func (se *SliceExpression) Pos() Position   { return se.Token.Pos() }
+func (se *SliceExpression) String() string {
+	start, end := "", ""
+	if se.Start != nil {
+		start = se.Start.String()
+	}
+	if se.End != nil {
+		end = se.End.String()
+	}
+	return fmt.Sprintf("(%s[%s:%s])", se.Left.String(), start, end)
+}
+
+type MemberExpression struct { // struct field access
+	Token    Token
+	Object   Expression
+	Property *Identifier
+}
+
+func (me *MemberExpression) expressionNode() {}
+func (me *MemberExpression) Pos() Position   { return me.Token.Pos() }
+func (me *MemberExpression) String() string {
+	return fmt.Sprintf("(%s.%s)", me.Object.String(), me.Property.String())
+}
+
 type InfixExpression struct {
+	Token    Token
 	Left     Expression
 	Operator string
 	Right    Expression
 }
 
 func (ie *InfixExpression) expressionNode() {}
+func (ie *InfixExpression) Pos() Position   { return ie.Token.Pos() }
 func (ie *InfixExpression) String() string {
 	return fmt.Sprintf("(%s %s %s)", ie.Left.String(), ie.Operator, ie.Right.String())
 }
 
 type PrefixExpression struct {
+	Token    Token
 	Operator string
 	Right    Expression
 }
 
 func (pe *PrefixExpression) expressionNode() {}
+func (pe *PrefixExpression) Pos() Position   { return pe.Token.Pos() }
 func (pe *PrefixExpression) String() string {
 	return fmt.Sprintf("(%s%s)", pe.Operator, pe.Right.String())
 }
 
 type CallExpression struct {
+	Token     Token
 	Function  Expression
 	Arguments []Expression
 }
 
 func (ce *CallExpression) expressionNode() {}
+func (ce *CallExpression) Pos() Position   { return ce.Function.Pos() } // the call site
 func (ce *CallExpression) String() string {
 	args := ""
 	for i, arg := range ce.Arguments {
@@ -251,11 +598,206 @@ func (ce *CallExpression) String() string {
 }
 
 type AssignmentExpression struct {
+	Token Token
 	Name  *Identifier
 	Value Expression
 }
 
 func (ae *AssignmentExpression) expressionNode() {}
+func (ae *AssignmentExpression) Pos() Position   { return ae.Name.Pos() }
 func (ae *AssignmentExpression) String() string {
 	return fmt.Sprintf("%s = %s", ae.Name.String(), ae.Value.String())
 }
+
+// CompoundAssignmentExpression is target op= value, or target++ and
+// target-- with an implicit Value of 1. Target is an *Identifier,
+// *IndexExpression or *MemberExpression.
+type CompoundAssignmentExpression struct {
+	Token    Token
+	Target   Expression
+	Operator string // the arithmetic operator: +, -, *, / or %
+	Value    Expression
+}
+
+func (ca *CompoundAssignmentExpression) expressionNode() {}
+func (ca *CompoundAssignmentExpression) Pos() Position   { return ca.Target.Pos() }
+func (ca *CompoundAssignmentExpression) String() string {
+	if ca.Token.Type == INCREMENT || ca.Token.Type == DECREMENT {
+		return ca.Target.String() + ca.Token.Value
+	}
+	return fmt.Sprintf("%s %s= %s", ca.Target.String(), ca.Operator, ca.Value.String())
+}
+
+type FieldAssignmentExpression struct { // struct field assignment
+	Token  Token
+	Target *MemberExpression
+	Value  Expression
+}
+
+func (fa *FieldAssignmentExpression) expressionNode() {}
+func (fa *FieldAssignmentExpression) Pos() Position   { return fa.Target.Pos() }
+func (fa *FieldAssignmentExpression) String() string {
+	return fmt.Sprintf("%s = %s", fa.Target.String(), fa.Value.String())
+}
+
+type IndexAssignmentExpression struct { // map[key] = value, array[i] = value
+	Token  Token
+	Target *IndexExpression
+	Value  Expression
+}
+
+func (ia *IndexAssignmentExpression) expressionNode() {}
+func (ia *IndexAssignmentExpression) Pos() Position   { return ia.Target.Pos() }
+func (ia *IndexAssignmentExpression) String() string {
+	return fmt.Sprintf("%s = %s", ia.Target.String(), ia.Value.String())
+}
+
+// MatchExpression evaluates to the Body of the first arm whose Pattern
+// matches Value and whose Guard, if any, is true.
+type MatchExpression struct {
+	Token Token
+	Value Expression
+	Arms  []*MatchArm
+}
+
+func (me *MatchExpression) expressionNode() {}
+func (me *MatchExpression) Pos() Position   { return me.Token.Pos() }
+func (me *MatchExpression) String() string {
+	arms := make([]string, len(me.Arms))
+	for idx, arm := range me.Arms {
+		arms[idx] = arm.String()
+	}
+	return fmt.Sprintf("match (%s) {%s}", me.Value.String(), strings.Join(arms, ", "))
+}
+
+// MatchArm is one `pattern circuit (guard) => body` of a match. The names
+// the pattern binds belong to the arm.
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression // nil without a guard
+	Body    Expression
+}
+
+func (ma *MatchArm) String() string {
+	if ma.Guard != nil {
+		return fmt.Sprintf("%s circuit (%s) => %s", ma.Pattern.String(), ma.Guard.String(), ma.Body.String())
+	}
+	return fmt.Sprintf("%s => %s", ma.Pattern.String(), ma.Body.String())
+}
+
+// Pattern is the left side of a match arm, which tests the shape of a
+// value and binds names to its parts.
+type Pattern interface {
+	Node
+	patternNode()
+}
+
+// WildcardPattern is `_`, which matches anything and binds nothing.
+type WildcardPattern struct {
+	Token Token
+}
+
+func (wp *WildcardPattern) patternNode()   {}
+func (wp *WildcardPattern) Pos() Position  { return wp.Token.Pos() }
+func (wp *WildcardPattern) String() string { return "_" }
+
+// BindingPattern matches anything and binds it to Name.
+type BindingPattern struct {
+	Name *Identifier
+}
+
+func (bp *BindingPattern) patternNode()   {}
+func (bp *BindingPattern) Pos() Position  { return bp.Name.Pos() }
+func (bp *BindingPattern) String() string { return bp.Name.String() }
+
+// LiteralPattern matches a value equal to a number, string or boolean
+// literal.
+type LiteralPattern struct {
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode()   {}
+func (lp *LiteralPattern) Pos() Position  { return lp.Value.Pos() }
+func (lp *LiteralPattern) String() string { return lp.Value.String() }
+
+// RangePattern matches a number from Start up to and including End, both
+// number literals.
+type RangePattern struct {
+	Token Token // the .. token
+	Start Expression
+	End   Expression
+}
+
+func (rp *RangePattern) patternNode()  {}
+func (rp *RangePattern) Pos() Position { return rp.Start.Pos() }
+func (rp *RangePattern) String() string {
+	return fmt.Sprintf("%s..%s", rp.Start.String(), rp.End.String())
+}
+
+// ArrayPattern matches a formation, or the characters of a string, with
+// one element for each of Elements. With a Rest it matches longer ones
+// too, and Rest matches the elements left over.
+type ArrayPattern struct {
+	Token    Token
+	Elements []Pattern
+	Rest     Pattern // nil without ...rest
+}
+
+func (ap *ArrayPattern) patternNode()  {}
+func (ap *ArrayPattern) Pos() Position { return ap.Token.Pos() }
+func (ap *ArrayPattern) String() string {
+	elements := make([]string, 0, len(ap.Elements)+1)
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// MapPattern matches a map holding each of Keys, literals, with a value
+// matching the pattern at the same position in Values. Other keys may be
+// present.
+type MapPattern struct {
+	Token  Token
+	Keys   []Expression
+	Values []Pattern
+}
+
+func (mp *MapPattern) patternNode()  {}
+func (mp *MapPattern) Pos() Position { return mp.Token.Pos() }
+func (mp *MapPattern) String() string {
+	pairs := make([]string, len(mp.Keys))
+	for idx, key := range mp.Keys {
+		pairs[idx] = key.String() + ": " + mp.Values[idx].String()
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// StructPattern matches a struct built by the garage named Garage, with
+// the named Fields matching the patterns at the same position in Values.
+type StructPattern struct {
+	Garage *Identifier
+	Fields []*Identifier
+	Values []Pattern
+}
+
+func (sp *StructPattern) patternNode()  {}
+func (sp *StructPattern) Pos() Position { return sp.Garage.Pos() }
+func (sp *StructPattern) String() string {
+	fields := make([]string, len(sp.Fields))
+	for idx, field := range sp.Fields {
+		fields[idx] = field.String() + ": " + sp.Values[idx].String()
+	}
+	return sp.Garage.String() + " {" + strings.Join(fields, ", ") + "}"
+}
+
+// BadExpression stands in for an expression that did not parse.
+type BadExpression struct {
+	Token Token // where the expression began
+}
+
+func (be *BadExpression) expressionNode() {}
+func (be *BadExpression) Pos() Position   { return be.Token.Pos() }
+func (be *BadExpression) String() string  { return "<bad expression>" }
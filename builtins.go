@@ -0,0 +1,407 @@
+package alonso
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// builtins lists the functions available to every program. The interpreter
+// binds them by name; the compiler refers to them by position, so new
+// entries must be appended rather than inserted.
+var builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{"telemetry", &Builtin{ // print function
+		HostFn: func(host *Host, args ...Object) Object {
+			parts := make([]string, len(args))
+			for i, arg := range args {
+				parts[i] = arg.Inspect()
+			}
+			if err := host.Print(strings.Join(parts, " ") + "\n"); err != nil {
+				return err
+			}
+			return NULL
+		},
+	}},
+	{"length", &Builtin{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *Array:
+				return newInteger(int64(len(arg.Elements)))
+			case *String:
+				return newInteger(int64(utf8.RuneCountInString(arg.Value)))
+			case *Map:
+				return newInteger(int64(len(arg.Pairs)))
+			default:
+				return newError("argument to `length` not supported, got %s", arg.Type())
+			}
+		},
+	}},
+	{"push", &Builtin{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+
+			if args[0].Type() != ARRAY_OBJ {
+				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+
+			newElements := make([]Object, length+1)
+			copy(newElements, arr.Elements)
+			newElements[length] = args[1]
+
+			return &Array{Elements: newElements}
+		},
+	}},
+	{"keys", &Builtin{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			m, ok := args[0].(*Map)
+			if !ok {
+				return newError("argument to `keys` must be MAP, got %s", args[0].Type())
+			}
+
+			elements := []Object{}
+			for _, entry := range m.Entries() {
+				elements = append(elements, entry.Key)
+			}
+			return &Array{Elements: elements}
+		},
+	}},
+	{"values", &Builtin{
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			m, ok := args[0].(*Map)
+			if !ok {
+				return newError("argument to `values` must be MAP, got %s", args[0].Type())
+			}
+
+			elements := []Object{}
+			for _, entry := range m.Entries() {
+				elements = append(elements, entry.Value)
+			}
+			return &Array{Elements: elements}
+		},
+	}},
+	{"has_key", &Builtin{
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+
+			m, ok := args[0].(*Map)
+			if !ok {
+				return newError("argument to `has_key` must be MAP, got %s", args[0].Type())
+			}
+
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError("unusable as map key: %s", args[1].Type())
+			}
+
+			_, exists := m.Get(key)
+			return nativeBoolToBooleanObject(exists)
+		},
+	}},
+	{"delete", &Builtin{ // removes the key in place and returns the map
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+
+			m, ok := args[0].(*Map)
+			if !ok {
+				return newError("argument to `delete` must be MAP, got %s", args[0].Type())
+			}
+
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError("unusable as map key: %s", args[1].Type())
+			}
+
+			m.Delete(key)
+			return m
+		},
+	}},
+	{"red_flag", &Builtin{ // raises a runtime error; recover catches it
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *String:
+				return newError("%s", arg.Value)
+			case *Struct:
+				if arg.Garage == incidentGarage {
+					return incidentError(arg)
+				}
+			}
+			return newError("argument to `red_flag` must be STRING or Incident, got %s", args[0].Type())
+		},
+	}},
+	{"map", &Builtin{ // returns a new array of fn(element)
+		HostFn: func(host *Host, args ...Object) Object {
+			arr, err := arrayAndCallback("map", args)
+			if err != nil {
+				return err
+			}
+
+			result := make([]Object, len(arr.Elements))
+			for idx, el := range arr.Elements {
+				val := host.Call(args[1], el)
+				if isError(val) {
+					return val
+				}
+				result[idx] = val
+			}
+			return &Array{Elements: result}
+		},
+	}},
+	{"filter", &Builtin{ // returns a new array of the elements fn accepts
+		HostFn: func(host *Host, args ...Object) Object {
+			arr, err := arrayAndCallback("filter", args)
+			if err != nil {
+				return err
+			}
+
+			result := []Object{}
+			for _, el := range arr.Elements {
+				keep := host.Call(args[1], el)
+				if isError(keep) {
+					return keep
+				}
+				if isTruthy(keep) {
+					result = append(result, el)
+				}
+			}
+			return &Array{Elements: result}
+		},
+	}},
+	{"reduce", &Builtin{ // folds fn(acc, element) over the array
+		HostFn: func(host *Host, args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+
+			arr, err := arrayAndCallback("reduce", args[:2])
+			if err != nil {
+				return err
+			}
+
+			elements := arr.Elements
+			var acc Object
+			if len(args) == 3 {
+				acc = args[2]
+			} else if len(elements) > 0 {
+				acc, elements = elements[0], elements[1:]
+			} else {
+				return newError("`reduce` of an empty array needs an initial value")
+			}
+
+			for _, el := range elements {
+				acc = host.Call(args[1], acc, el)
+				if isError(acc) {
+					return acc
+				}
+			}
+			return acc
+		},
+	}},
+	{"sort_by", &Builtin{ // returns a new array ordered by fn(element)
+		HostFn: func(host *Host, args ...Object) Object {
+			arr, err := arrayAndCallback("sort_by", args)
+			if err != nil {
+				return err
+			}
+
+			keys := make([]Object, len(arr.Elements))
+			for idx, el := range arr.Elements {
+				key := host.Call(args[1], el)
+				if isError(key) {
+					return key
+				}
+				if !isNumeric(key) && key.Type() != STRING_OBJ {
+					return newError("`sort_by` keys must be INTEGER, NUMBER or STRING, got %s", key.Type())
+				}
+				if idx > 0 && isNumeric(key) != isNumeric(keys[0]) {
+					return newError("`sort_by` keys must all have the same type, got %s and %s", keys[0].Type(), key.Type())
+				}
+				keys[idx] = key
+			}
+
+			order := make([]int, len(keys))
+			for idx := range order {
+				order[idx] = idx
+			}
+			sort.SliceStable(order, func(a, b int) bool {
+				return keyLess(keys[order[a]], keys[order[b]])
+			})
+
+			result := make([]Object, len(order))
+			for idx, from := range order {
+				result[idx] = arr.Elements[from]
+			}
+			return &Array{Elements: result}
+		},
+	}},
+	{"each", &Builtin{ // calls fn(element) for its side effects
+		HostFn: func(host *Host, args ...Object) Object {
+			arr, err := arrayAndCallback("each", args)
+			if err != nil {
+				return err
+			}
+
+			for _, el := range arr.Elements {
+				if val := host.Call(args[1], el); isError(val) {
+					return val
+				}
+			}
+			return NULL
+		},
+	}},
+	{"assert_eq", &Builtin{ // fails unless actual == expected
+		Fn: func(args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+
+			actual, expected := args[0], args[1]
+			if objectsEqual(actual, expected) {
+				return NULL
+			}
+			if actual.Type() != expected.Type() {
+				return assertionError("assert_eq", args[2:], "expected %s (%s), got %s (%s)",
+					expected.Inspect(), expected.Type(), actual.Inspect(), actual.Type())
+			}
+			return assertionError("assert_eq", args[2:], "expected %s, got %s", expected.Inspect(), actual.Inspect())
+		},
+	}},
+	{"assert_true", &Builtin{ // fails unless the value is truthy
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+
+			if isTruthy(args[0]) {
+				return NULL
+			}
+			return assertionError("assert_true", args[1:], "got %s", args[0].Inspect())
+		},
+	}},
+	{"assert_error", &Builtin{ // fails unless fn() raises; returns the Incident
+		HostFn: func(host *Host, args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+
+			want := ""
+			if len(args) == 2 {
+				str, ok := args[1].(*String)
+				if !ok {
+					return newError("second argument to `assert_error` must be STRING, got %s", args[1].Type())
+				}
+				want = str.Value
+			}
+
+			err, ok := host.Call(args[0]).(*Error)
+			if !ok {
+				return assertionError("assert_error", nil, "no error was raised")
+			}
+			if !strings.Contains(err.Message, want) {
+				return assertionError("assert_error", nil, "expected an error containing %q, got %q", want, err.Message)
+			}
+			return newIncident(err)
+		},
+	}},
+	{"radio", &Builtin{ // reads a line from stdin; null at end of input
+		HostFn: func(host *Host, args ...Object) Object {
+			if len(args) > 1 {
+				return newError("wrong number of arguments. got=%d, want=0 or 1", len(args))
+			}
+
+			if len(args) == 1 {
+				prompt, ok := args[0].(*String)
+				if !ok {
+					return newError("argument to `radio` must be STRING, got %s", args[0].Type())
+				}
+				if err := host.Print(prompt.Value); err != nil {
+					return err
+				}
+			}
+
+			line, err := host.ReadLine()
+			if err == io.EOF {
+				return NULL
+			}
+			if err != nil {
+				return newError("radio: %s", err)
+			}
+			return &String{Value: line}
+		},
+	}},
+}
+
+// assertionError reports a failed assertion, prefixed by the optional
+// message the script passed in extra.
+func assertionError(name string, extra []Object, format string, a ...interface{}) *Error {
+	msg := fmt.Sprintf(name+" failed: "+format, a...)
+	if len(extra) > 0 {
+		msg = fmt.Sprintf("%s: %s", extra[0].Inspect(), msg)
+	}
+	return newError("%s", msg)
+}
+
+// arrayAndCallback checks the (array, function) arguments shared by the
+// higher-order builtins.
+func arrayAndCallback(name string, args []Object) (*Array, *Error) {
+	if len(args) != 2 {
+		return nil, newError("wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return nil, newError("argument to `%s` must be ARRAY, got %s", name, args[0].Type())
+	}
+
+	switch args[1].(type) {
+	case *Function, *Closure, *Builtin, *Garage:
+		return arr, nil
+	default:
+		return nil, newError("second argument to `%s` must be FUNCTION, got %s", name, args[1].Type())
+	}
+}
+
+func keyLess(a, b Object) bool {
+	if isNumeric(a) {
+		return compareNumbers(a, b) == -1
+	}
+	return a.(*String).Value < b.(*String).Value
+}
+
+func lookupBuiltin(name string) (int, *Builtin) {
+	for idx, def := range builtins {
+		if def.Name == name {
+			return idx, def.Builtin
+		}
+	}
+	return -1, nil
+}
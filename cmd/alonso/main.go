@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"alonso"
+)
+
+// options are the command-line flags that choose how programs run.
+type options struct {
+	vm      bool // run on the bytecode VM
+	sandbox bool // run as untrusted code
+}
+
+// sandboxLimits bound programs run with --sandbox.
+var sandboxLimits = alonso.Limits{
+	MaxSteps:  100_000_000,
+	MaxMemory: 256 << 20,
+	MaxOutput: 1 << 20,
+}
+
+func newBackend(opts options) alonso.Backend {
+	var backend alonso.Backend = alonso.NewInterpreter()
+	if opts.vm {
+		backend = alonso.NewMachine()
+	}
+
+	if opts.sandbox {
+		*backend.Restrictions() = alonso.SandboxProfile()
+		*backend.Resources() = sandboxLimits
+	}
+	return backend
+}
+
+// printError reports err after the calls leading to it, if any. A program
+// that did not parse has had its syntax errors reported already.
+func printError(w io.Writer, prefix string, err error) {
+	var parseErr *alonso.ParseError
+	if errors.As(err, &parseErr) {
+		return
+	}
+	if rt, ok := err.(*alonso.RuntimeError); ok {
+		fmt.Fprint(w, rt.Traceback())
+	}
+	fmt.Fprintf(w, "%s: %v\n", prefix, err)
+}
+
+func main() {
+	opts := options{}
+	args := []string{}
+	for _, arg := range os.Args[1:] {
+		switch arg {
+		case "--vm":
+			opts.vm = true
+		case "--sandbox":
+			opts.sandbox = true
+		default:
+			args = append(args, arg)
+		}
+	}
+
+	if len(args) > 0 && args[0] == "debug-lexer" {
+		input := "x = 5"
+		lexer := alonso.NewLexer(input)
+
+		for {
+			token := lexer.NextToken()
+			fmt.Printf("Token: %s, Value: '%s'\n", token.Type.String(), token.Value)
+			if token.Type == alonso.EOF {
+				break
+			}
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "test" {
+		os.Exit(runTests(os.Stdout, args[1:], opts))
+	}
+
+	if len(args) > 0 {
+		// Run file
+		filename := args[0]
+		if !strings.HasSuffix(filename, ".alo") {
+			fmt.Fprintln(os.Stderr, "Error: Alonso files must have .alo extension")
+			os.Exit(1)
+		}
+
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			os.Exit(1)
+		}
+
+		interpreter := newBackend(opts)
+		err = interpreter.ExecuteFile(filename, string(content))
+		if err != nil {
+			printError(interpreter.IO().Stderr, "Runtime error", err)
+			os.Exit(1)
+		}
+	} else {
+		// REPL mode
+		fmt.Println("Welcome to Alonso - The F1 Programming Language!")
+		fmt.Println("Type 'pit' to exit")
+
+		interpreter := newBackend(opts)
+		streams := interpreter.IO()
+
+		for {
+			fmt.Fprint(streams.Stdout, "alonso> ")
+			input, err := streams.ReadLine()
+			if err != nil {
+				break
+			}
+
+			line := strings.TrimSpace(input)
+			if line == "pit" {
+				fmt.Fprintln(streams.Stdout, "Thanks for racing with Alonso!")
+				break
+			}
+
+			if line == "" {
+				continue
+			}
+
+			err = interpreter.Execute(line)
+			if err != nil {
+				printError(streams.Stderr, "Error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"alonso"
+)
+
+// TestPrintError checks that syntax errors, which the parser has already
+// reported, are not reported again as runtime errors.
+func TestPrintError(t *testing.T) {
+	backend := newBackend(options{})
+	var stderr bytes.Buffer
+	backend.IO().Stderr = &stderr
+
+	err := backend.ExecuteFile("bad.alo", "grid x 5")
+	printError(&stderr, "Runtime error", err)
+	if got, want := stderr.String(), "Parser error: bad.alo:1:8: expected next token to be ASSIGN, got NUMBER instead\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+
+	stderr.Reset()
+	err = backend.ExecuteFile("bad.alo", "grid x = 1 / 0")
+	printError(&stderr, "Runtime error", err)
+	if _, ok := err.(*alonso.RuntimeError); !ok || !bytes.HasPrefix(stderr.Bytes(), []byte("Runtime error: bad.alo:1:")) {
+		t.Errorf("stderr = %q for %v", stderr.String(), err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"alonso"
+)
+
+// runTests implements `alonso test`. It finds *_test.alo files under paths
+// and runs every top-level `pace test_*` in a fresh backend, so tests cannot
+// see each other's state. It returns the process exit code.
+func runTests(out io.Writer, paths []string, opts options) int {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files, err := findTestFiles(paths)
+	if err != nil {
+		fmt.Fprintf(out, "Error: %v\n", err)
+		return 1
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(out, "no test files found")
+		return 1
+	}
+
+	passed, failed := 0, 0
+	for _, file := range files {
+		p, f := runTestFile(out, file, opts)
+		passed += p
+		failed += f
+	}
+
+	fmt.Fprintf(out, "\n%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func findTestFiles(paths []string) ([]string, error) {
+	files := []string{}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.HasSuffix(file, "_test.alo") {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// runTestFile runs the tests in one file and returns how many passed and
+// failed. A file that does not parse counts as a single failure.
+func runTestFile(out io.Writer, file string, opts options) (int, int) {
+	fmt.Fprintln(out, file)
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(out, "  FAIL  %v\n", err)
+		return 0, 1
+	}
+
+	parser := alonso.NewParser(alonso.NewLexer(string(content)))
+	program := parser.ParseProgram()
+	if len(parser.Errors()) > 0 {
+		fmt.Fprintln(out, "  FAIL  parse errors")
+		for _, err := range parser.Diagnostics() {
+			fmt.Fprintf(out, "        %s:%s\n", file, err)
+		}
+		return 0, 1
+	}
+
+	names := []string{}
+	for _, stmt := range program.Statements {
+		if pace, ok := stmt.(*alonso.PaceStatement); ok && strings.HasPrefix(pace.Name.Value, "test_") {
+			names = append(names, pace.Name.Value)
+		}
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(out, "  no tests")
+		return 0, 0
+	}
+
+	passed, failed := 0, 0
+	for _, name := range names {
+		backend := newBackend(opts)
+		backend.IO().Stdout = out
+		backend.IO().Stderr = out
+
+		err := backend.ExecuteFile(file, string(content))
+		if err == nil {
+			_, err = backend.Call(name)
+		}
+
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "  FAIL  %s\n", name)
+			report := err.Error()
+			if rt, ok := err.(*alonso.RuntimeError); ok {
+				report = rt.Traceback() + report
+			}
+			for _, line := range strings.Split(report, "\n") {
+				fmt.Fprintf(out, "        %s\n", line)
+			}
+			continue
+		}
+
+		passed++
+		fmt.Fprintf(out, "  PASS  %s\n", name)
+	}
+
+	return passed, failed
+}
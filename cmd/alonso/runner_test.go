@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestScriptTests runs the *_test.alo suites on both backends.
+func TestScriptTests(t *testing.T) {
+	for _, opts := range []options{{}, {vm: true}, {sandbox: true}} {
+		var out bytes.Buffer
+		if code := runTests(&out, []string{"../../tests"}, opts); code != 0 {
+			t.Errorf("alonso test tests (%+v) exited with %d\n%s", opts, code, out.String())
+		}
+	}
+}
+
+func TestScriptTestsReportFailures(t *testing.T) {
+	var out bytes.Buffer
+	if code := runTests(&out, []string{"../../tests/runner/failures.alo"}, options{}); code != 1 {
+		t.Errorf("exit code = %d, want 1", code)
+	}
+	for _, want := range []string{
+		"PASS  test_passes",
+		"FAIL  test_fails",
+		"arithmetic: assert_eq failed: expected 5, got 4",
+		"FAIL  test_errors",
+		"Traceback (most recent call last):",
+		"1 passed, 2 failed",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package alonso
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Instructions is a flat stream of encoded opcodes and their operands.
+type Instructions []byte
+
+type Opcode byte
+
+// SourceMap records, for each run of instructions, the position of the AST
+// node that emitted them. Entries are in increasing offset order.
+type SourceMap []SourceMapEntry
+
+type SourceMapEntry struct {
+	Offset int
+	Pos    Position
+}
+
+// Lookup returns the position of the instruction starting at offset.
+func (sm SourceMap) Lookup(offset int) Position {
+	pos := Position{}
+	for _, entry := range sm {
+		if entry.Offset > offset {
+			break
+		}
+		pos = entry.Pos
+	}
+	return pos
+}
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+	OpDup
+	OpNull
+	OpTrue
+	OpFalse
+
+	// Arithmetic and comparison
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpLessEqual
+	OpGreater
+	OpGreaterEqual
+	OpAnd
+	OpOr
+	OpMinus
+	OpBang
+
+	// Control flow
+	OpJump
+	OpJumpNotTruthy
+	OpPushHandler
+	OpPopHandler
+	OpIterate
+	OpRange
+	OpIterNext
+	OpEnterScope
+	OpLeaveScope
+	OpInRange
+	OpMatch
+	OpNoMatch
+
+	// Variables
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetOuter
+	OpSetOuter
+	OpGetBuiltin
+	OpGetName
+	OpSetName
+	OpImport
+
+	// Data structures
+	OpArray
+	OpMap
+	OpIndex
+	OpSlice
+	OpSetIndex
+	OpGetField
+	OpSetField
+	OpInterpolate
+
+	// Functions
+	OpClosure
+	OpCall
+	OpReturnValue
+	OpReturn
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpDup:      {"OpDup", []int{1}}, // number of values to copy
+	OpNull:     {"OpNull", []int{}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLess:         {"OpLess", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreater:      {"OpGreater", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+	OpAnd:          {"OpAnd", []int{}},
+	OpOr:           {"OpOr", []int{}},
+	OpMinus:        {"OpMinus", []int{}},
+	OpBang:         {"OpBang", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpPushHandler:   {"OpPushHandler", []int{2}}, // where recover starts
+	OpPopHandler:    {"OpPopHandler", []int{}},
+	OpIterate:       {"OpIterate", []int{1}}, // 1 to yield map values rather than keys
+	OpRange:         {"OpRange", []int{}},
+	OpIterNext:      {"OpIterNext", []int{2}},   // where to go once it is done
+	OpEnterScope:    {"OpEnterScope", []int{2}}, // constant holding the scope's layout
+	OpLeaveScope:    {"OpLeaveScope", []int{}},
+	OpInRange:       {"OpInRange", []int{}},
+	OpMatch:         {"OpMatch", []int{2, 2}}, // pattern constant, where to go if it does not match
+	OpNoMatch:       {"OpNoMatch", []int{}},
+
+	OpGetGlobal:  {"OpGetGlobal", []int{2}},
+	OpSetGlobal:  {"OpSetGlobal", []int{2}},
+	OpGetLocal:   {"OpGetLocal", []int{2}},
+	OpSetLocal:   {"OpSetLocal", []int{2}},
+	OpGetOuter:   {"OpGetOuter", []int{1, 2}}, // scope depth, slot
+	OpSetOuter:   {"OpSetOuter", []int{1, 2}},
+	OpGetBuiltin: {"OpGetBuiltin", []int{1}},
+	OpGetName:    {"OpGetName", []int{2}}, // constant holding the name
+	OpSetName:    {"OpSetName", []int{2}},
+	OpImport:     {"OpImport", []int{2}}, // constant holding the path
+
+	OpArray:    {"OpArray", []int{2}},
+	OpMap:      {"OpMap", []int{2}}, // number of key/value pairs
+	OpIndex:    {"OpIndex", []int{}},
+	OpSlice:    {"OpSlice", []int{}},
+	OpSetIndex: {"OpSetIndex", []int{}},
+	OpGetField: {"OpGetField", []int{2}},
+	OpSetField: {"OpSetField", []int{2}},
+
+	OpInterpolate: {"OpInterpolate", []int{2}}, // number of parts
+
+	OpClosure:     {"OpClosure", []int{2}},
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+}
+
+func LookupOpcode(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// MakeInstruction encodes an opcode and its operands, big-endian.
+func MakeInstruction(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	length := 1
+	for _, w := range def.OperandWidths {
+		length += w
+	}
+
+	instruction := make([]byte, length)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 { return binary.BigEndian.Uint16(ins) }
+func ReadUint8(ins Instructions) uint8   { return uint8(ins[0]) }
+
+// String disassembles the instructions, one per line.
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := LookupOpcode(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s", i, def.Name)
+		for _, o := range operands {
+			fmt.Fprintf(&out, " %d", o)
+		}
+		out.WriteString("\n")
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
@@ -0,0 +1,1015 @@
+package alonso
+
+import (
+	"fmt"
+)
+
+type Bytecode struct {
+	File         string
+	Instructions Instructions
+	Positions    SourceMap
+	Constants    []Object
+}
+
+// loopJumps collects the placeholder jumps emitted for break_flag and
+// continue_race until the loop knows where they should land.
+type loopJumps struct {
+	label     string
+	breaks    []int
+	continues []int
+	handlers  int // safety_car handlers active when the loop began
+	scopes    int // block and loop-in scopes open when the loop began
+}
+
+type CompilationScope struct {
+	instructions Instructions
+	positions    SourceMap
+	loops        []*loopJumps
+	handlers     int // safety_car bodies being compiled
+	scopes       int // block and loop-in scopes open at this point of the frame
+}
+
+type Compiler struct {
+	file        string // recorded on functions so errors can be located
+	constants   []Object
+	symbolTable *SymbolTable
+	scopes      []CompilationScope
+	scopeIndex  int
+	pos         Position // position of the node being compiled
+}
+
+var infixOpcodes = map[string]Opcode{
+	"+":  OpAdd,
+	"-":  OpSub,
+	"*":  OpMul,
+	"/":  OpDiv,
+	"%":  OpMod,
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	"<":  OpLess,
+	"<=": OpLessEqual,
+	">":  OpGreater,
+	">=": OpGreaterEqual,
+	"&&": OpAnd,
+	"||": OpOr,
+}
+
+func NewCompiler() *Compiler {
+	return NewCompilerWithState(NewSymbolTable(), []Object{})
+}
+
+// NewCompilerWithState continues from an earlier compilation, so the REPL
+// can keep its globals and constants between lines.
+func NewCompilerWithState(s *SymbolTable, constants []Object) *Compiler {
+	return &Compiler{
+		constants:   constants,
+		symbolTable: s,
+		scopes:      []CompilationScope{{instructions: Instructions{}}},
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		File:         c.file,
+		Instructions: c.currentInstructions(),
+		Positions:    c.scopes[c.scopeIndex].positions,
+		Constants:    c.constants,
+	}
+}
+
+// Compile emits bytecode for node. Instructions are tagged with the position
+// of the innermost node being compiled, so the VM can locate errors the same
+// way Interpreter.Eval does.
+func (c *Compiler) Compile(node Node) error {
+	previous := c.pos
+	if pos := node.Pos(); pos.Line > 0 {
+		c.pos = pos
+	}
+	defer func() { c.pos = previous }()
+
+	return c.compile(node)
+}
+
+func (c *Compiler) compile(node Node) error {
+	switch node := node.(type) {
+
+	// Statements
+	case *Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ExpressionStatement:
+		if assign, ok := node.Expression.(*AssignmentExpression); ok {
+			// The value of a bare assignment is never used, so skip the
+			// copy and pop an AssignmentExpression would otherwise need.
+			if err := c.Compile(assign.Value); err != nil {
+				return err
+			}
+			c.emitAssign(assign.Name.Value)
+			return nil
+		}
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(OpPop)
+
+	case *GridStatement:
+		symbol, err := c.compileBinding(node.Name.Value, node.Value)
+		if err != nil {
+			return err
+		}
+		c.emitSet(symbol)
+
+	case *PaceStatement:
+		// Define first so the body can call itself.
+		symbol := c.symbolTable.Define(node.Name.Value)
+		if err := c.compileFunction(node.Name.Value, node.Parameters, node.Body); err != nil {
+			return err
+		}
+		c.emitSet(symbol)
+
+	case *GarageStatement:
+		garage := &Garage{Name: node.Name.Value}
+		for _, field := range node.Fields {
+			garage.Fields = append(garage.Fields, field.Value)
+		}
+		c.emit(OpConstant, c.addConstant(garage))
+		c.emitSet(c.symbolTable.Define(node.Name.Value))
+
+	case *ImportStatement:
+		c.emit(OpImport, c.addConstant(&String{Value: node.Path}))
+		c.emitSet(c.symbolTable.Define(node.Alias.Value))
+
+	case *CircuitStatement:
+		return c.compileCircuitStatement(node)
+
+	case *StrategyStatement:
+		return c.compileStrategyStatement(node)
+
+	case *LoopStatement:
+		return c.compileLoopStatement(node)
+
+	case *LoopInStatement:
+		return c.compileLoopInStatement(node)
+
+	case *SafetyCarStatement:
+		return c.compileSafetyCarStatement(node)
+
+	case *WhileRacingStatement:
+		return c.compileWhileRacingStatement(node)
+
+	case *ReturnPitStatement:
+		if node.Value != nil {
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+		} else {
+			c.emit(OpNull)
+		}
+		c.emit(OpReturnValue)
+
+	case *BreakFlagStatement:
+		loop := c.targetLoop(node.Label)
+		if loop == nil {
+			return fmt.Errorf("break_flag outside of a loop")
+		}
+		c.popHandlers(loop)
+		c.leaveScopes(loop)
+		loop.breaks = append(loop.breaks, c.emit(OpJump, 9999))
+
+	case *ContinueRaceStatement:
+		loop := c.targetLoop(node.Label)
+		if loop == nil {
+			return fmt.Errorf("continue_race outside of a loop")
+		}
+		c.popHandlers(loop)
+		c.leaveScopes(loop)
+		loop.continues = append(loop.continues, c.emit(OpJump, 9999))
+
+	case *BlockStatement:
+		return c.compileBlockStatement(node)
+
+	// Expressions
+	case *IntegerLiteral:
+		c.emit(OpConstant, c.addConstant(newInteger(node.Value)))
+
+	case *NumberLiteral:
+		c.emit(OpConstant, c.addConstant(&Number{Value: node.Value}))
+
+	case *StringLiteral:
+		c.emit(OpConstant, c.addConstant(&String{Value: node.Value}))
+
+	case *InterpolatedString:
+		for _, part := range node.Parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+		}
+		c.emit(OpInterpolate, len(node.Parts))
+
+	case *BooleanLiteral:
+		if node.Value {
+			c.emit(OpTrue)
+		} else {
+			c.emit(OpFalse)
+		}
+
+	case *MatchExpression:
+		return c.compileMatchExpression(node)
+
+	case *FormationLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(OpArray, len(node.Elements))
+
+	case *MapLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(OpMap, len(node.Pairs))
+
+	case *IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(OpIndex)
+
+	case *SliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		for _, bound := range []Expression{node.Start, node.End} {
+			if bound == nil {
+				c.emit(OpNull)
+			} else if err := c.Compile(bound); err != nil {
+				return err
+			}
+		}
+		c.emit(OpSlice)
+
+	case *MemberExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		c.emit(OpGetField, c.addConstant(&String{Value: node.Property.Value}))
+
+	case *Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			// Globals may be defined after the code that uses them, as the
+			// interpreter looks names up at runtime; defer to the VM.
+			c.emit(OpGetName, c.addConstant(&String{Value: node.Value}))
+			return nil
+		}
+		c.emitGet(symbol)
+
+	case *PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(OpBang)
+		case "-":
+			c.emit(OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *InfixExpression:
+		op, ok := infixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(op)
+
+	case *PaceLiteral:
+		return c.compileFunction(functionName(node), node.Parameters, node.Body)
+
+	case *CallExpression:
+		if len(node.Arguments) > 255 {
+			return fmt.Errorf("too many arguments in call to %s", node.Function.String())
+		}
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, arg := range node.Arguments {
+			if err := c.Compile(arg); err != nil {
+				return err
+			}
+		}
+		c.emit(OpCall, len(node.Arguments))
+
+	case *AssignmentExpression:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(OpDup, 1)
+		c.emitAssign(node.Name.Value)
+
+	case *CompoundAssignmentExpression:
+		return c.compileCompoundAssignment(node)
+
+	case *FieldAssignmentExpression:
+		if err := c.Compile(node.Target.Object); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(OpSetField, c.addConstant(&String{Value: node.Target.Property.Value}))
+
+	case *IndexAssignmentExpression:
+		if err := c.Compile(node.Target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(OpSetIndex)
+
+	default:
+		return fmt.Errorf("unknown node type: %T", node)
+	}
+
+	return nil
+}
+
+// compileBinding compiles the value bound to name and defines the name. A
+// pace literal can see its own name, as a PaceStatement can.
+func (c *Compiler) compileBinding(name string, value Expression) (Symbol, error) {
+	if _, ok := value.(*PaceLiteral); ok {
+		symbol := c.symbolTable.Define(name)
+		return symbol, c.Compile(value)
+	}
+
+	if err := c.Compile(value); err != nil {
+		return Symbol{}, err
+	}
+	return c.symbolTable.Define(name), nil
+}
+
+// compileCompoundAssignment evaluates the parts of the target once, using
+// OpDup to keep them for the store after the current value is read.
+func (c *Compiler) compileCompoundAssignment(node *CompoundAssignmentExpression) error {
+	op := infixOpcodes[node.Operator]
+
+	switch target := node.Target.(type) {
+	case *Identifier:
+		if err := c.Compile(target); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(op)
+		c.emit(OpDup, 1)
+		c.emitAssign(target.Value)
+
+	case *IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		c.emit(OpDup, 2)
+		c.emit(OpIndex)
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(op)
+		c.emit(OpSetIndex)
+
+	case *MemberExpression:
+		if err := c.Compile(target.Object); err != nil {
+			return err
+		}
+		field := c.addConstant(&String{Value: target.Property.Value})
+		c.emit(OpDup, 1)
+		c.emit(OpGetField, field)
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(op)
+		c.emit(OpSetField, field)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileCircuitStatement(node *CircuitStatement) error {
+	branches := append([]*ElseCircuit{{Condition: node.Condition, Consequence: node.Consequence}}, node.ElseCircuits...)
+
+	// Each taken branch jumps past the rest of the chain.
+	var endJumps []int
+	for idx, branch := range branches {
+		if err := c.Compile(branch.Condition); err != nil {
+			return err
+		}
+
+		jumpNotTruthyPos := c.emit(OpJumpNotTruthy, 9999)
+
+		if err := c.Compile(branch.Consequence); err != nil {
+			return err
+		}
+
+		if idx < len(branches)-1 || node.Alternative != nil {
+			endJumps = append(endJumps, c.emit(OpJump, 9999))
+		}
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	}
+
+	if node.Alternative != nil {
+		if err := c.Compile(node.Alternative); err != nil {
+			return err
+		}
+	}
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compileStrategyStatement keeps the value in a slot no name can refer to
+// and tests the case values against it in order. A value that matches
+// jumps to its case's body; one that does not falls through to the next.
+func (c *Compiler) compileStrategyStatement(node *StrategyStatement) error {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	value := c.symbolTable.Define("<strategy>")
+	c.emitSet(value)
+
+	var endJumps []int
+	for _, cs := range node.Cases {
+		var bodyJumps []int
+		nextCasePos := -1
+		for idx, exp := range cs.Values {
+			c.emitGet(value)
+			if rng, ok := exp.(*RangeExpression); ok {
+				if err := c.Compile(rng.Start); err != nil {
+					return err
+				}
+				if err := c.Compile(rng.End); err != nil {
+					return err
+				}
+				c.emit(OpInRange)
+			} else {
+				if err := c.Compile(exp); err != nil {
+					return err
+				}
+				c.emit(OpEqual)
+			}
+
+			missPos := c.emit(OpJumpNotTruthy, 9999)
+			if idx == len(cs.Values)-1 {
+				nextCasePos = missPos
+				break
+			}
+			bodyJumps = append(bodyJumps, c.emit(OpJump, 9999))
+			c.changeOperand(missPos, len(c.currentInstructions()))
+		}
+
+		for _, pos := range bodyJumps {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+		if err := c.Compile(cs.Body); err != nil {
+			return err
+		}
+		endJumps = append(endJumps, c.emit(OpJump, 9999))
+		c.changeOperand(nextCasePos, len(c.currentInstructions()))
+	}
+
+	if node.Default != nil {
+		if err := c.Compile(node.Default); err != nil {
+			return err
+		}
+	}
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compileMatchExpression keeps the value in a slot no name can refer to.
+// For each arm, OpMatch takes the value and the garages its struct
+// patterns name, and pushes the values its pattern binds, which are
+// stored in a scope of the arm's own, or jumps to the next arm; the body
+// of the arm taken leaves the value of the match on the stack.
+func (c *Compiler) compileMatchExpression(node *MatchExpression) error {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	value := c.symbolTable.Define("<match>")
+	c.emitSet(value)
+
+	var endJumps []int
+	for _, arm := range node.Arms {
+		if err := c.compileMatchArm(arm, value, &endJumps); err != nil {
+			return err
+		}
+	}
+
+	c.emitGet(value)
+	c.emit(OpNoMatch)
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+func (c *Compiler) compileMatchArm(arm *MatchArm, value Symbol, endJumps *[]int) error {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	c.emitGet(value)
+	structs := structPatterns(arm.Pattern)
+	for _, sp := range structs {
+		if err := c.Compile(sp.Garage); err != nil {
+			return err
+		}
+	}
+	pattern := c.addConstant(&MatchPattern{Pattern: arm.Pattern, Structs: structs})
+	matchPos := c.emit(OpMatch, pattern, 9999)
+	names := patternNames(arm.Pattern)
+	for idx := len(names) - 1; idx >= 0; idx-- {
+		c.emitSet(c.symbolTable.Define(names[idx]))
+	}
+
+	guardPos := -1
+	if arm.Guard != nil {
+		if err := c.Compile(arm.Guard); err != nil {
+			return err
+		}
+		guardPos = c.emit(OpJumpNotTruthy, 9999)
+	}
+
+	if err := c.Compile(arm.Body); err != nil {
+		return err
+	}
+	*endJumps = append(*endJumps, c.emit(OpJump, 9999))
+
+	nextPos := len(c.currentInstructions())
+	copy(c.currentInstructions()[matchPos:], MakeInstruction(OpMatch, pattern, nextPos))
+	if guardPos >= 0 {
+		c.changeOperand(guardPos, nextPos)
+	}
+	return nil
+}
+
+// compileBlockStatement runs the block in a scope of its own, opened by
+// OpEnterScope each time the block is entered, just as the interpreter
+// gives each run of a block a new environment. A pace created in a loop
+// body therefore keeps the grids of its own pass.
+func (c *Compiler) compileBlockStatement(node *BlockStatement) error {
+	enterPos := c.emit(OpEnterScope, 9999)
+	c.symbolTable = NewFunctionSymbolTable(c.symbolTable)
+	c.scopes[c.scopeIndex].scopes++
+
+	c.declareNames(node.Statements)
+	for _, s := range node.Statements {
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+	}
+
+	layout := &ScopeLayout{SlotNames: c.symbolTable.SlotNames()}
+	c.changeOperand(enterPos, c.addConstant(layout))
+	c.symbolTable = c.symbolTable.Outer
+	c.scopes[c.scopeIndex].scopes--
+
+	c.emit(OpLeaveScope)
+	return nil
+}
+
+func (c *Compiler) compileSafetyCarStatement(node *SafetyCarStatement) error {
+	handlerPos := c.emit(OpPushHandler, 9999)
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.handlers++
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	scope.handlers--
+
+	c.emit(OpPopHandler)
+	jumpPos := c.emit(OpJump, 9999)
+
+	// The VM unwinds to here with the incident on the stack, which only
+	// the recover block can see.
+	c.changeOperand(handlerPos, len(c.currentInstructions()))
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+	if node.Param != nil {
+		c.emitSet(c.symbolTable.Define(node.Param.Value))
+	} else {
+		c.emit(OpPop)
+	}
+
+	if err := c.Compile(node.Handler); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+func (c *Compiler) compileLoopStatement(node *LoopStatement) error {
+	// The interpreter gives each loop its own environment.
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	if node.Init != nil {
+		if err := c.Compile(node.Init); err != nil {
+			return err
+		}
+	}
+
+	conditionPos := len(c.currentInstructions())
+	exitPos := -1
+	if node.Condition != nil {
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		exitPos = c.emit(OpJumpNotTruthy, 9999)
+	}
+
+	loop := c.enterLoop(node.Label)
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.leaveLoop()
+
+	updatePos := len(c.currentInstructions())
+	if node.Update != nil {
+		if err := c.Compile(node.Update); err != nil {
+			return err
+		}
+	}
+	c.emit(OpJump, conditionPos)
+
+	endPos := len(c.currentInstructions())
+	if exitPos >= 0 {
+		c.changeOperand(exitPos, endPos)
+	}
+	c.patchLoop(loop, updatePos, endPos)
+
+	return nil
+}
+
+// compileLoopInStatement keeps the iterator in a slot of the loop's scope
+// that no name can refer to, so break_flag leaves nothing on the stack.
+// OpIterNext pushes each key and value, or jumps past the loop when done.
+// Each pass then runs in a scope of its own, opened by OpEnterScope, so
+// that paces created in the body keep the values of their own pass, as
+// they do in the interpreter.
+func (c *Compiler) compileLoopInStatement(node *LoopInStatement) error {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	if rng, ok := node.Iterable.(*RangeExpression); ok {
+		if err := c.Compile(rng.Start); err != nil {
+			return err
+		}
+		if err := c.Compile(rng.End); err != nil {
+			return err
+		}
+		c.emit(OpRange)
+	} else {
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+		withKeys := 0
+		if node.Key != nil {
+			withKeys = 1
+		}
+		c.emit(OpIterate, withKeys)
+	}
+	iterator := c.symbolTable.Define("<iterator>")
+	c.emitSet(iterator)
+
+	nextPos := len(c.currentInstructions())
+	c.emitGet(iterator)
+	exitPos := c.emit(OpIterNext, 9999)
+
+	enterPos := c.emit(OpEnterScope, 9999)
+	c.symbolTable = NewFunctionSymbolTable(c.symbolTable)
+	c.scopes[c.scopeIndex].scopes++
+
+	c.emitSet(c.symbolTable.Define(node.Value.Value))
+	if node.Key != nil {
+		c.emitSet(c.symbolTable.Define(node.Key.Value))
+	} else {
+		c.emit(OpPop)
+	}
+
+	loop := c.enterLoop(node.Label)
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.leaveLoop()
+
+	layout := &ScopeLayout{SlotNames: c.symbolTable.SlotNames()}
+	c.changeOperand(enterPos, c.addConstant(layout))
+	c.symbolTable = c.symbolTable.Outer
+	c.scopes[c.scopeIndex].scopes--
+
+	continuePos := c.emit(OpLeaveScope)
+	c.emit(OpJump, nextPos)
+	breakPos := c.emit(OpLeaveScope)
+
+	endPos := len(c.currentInstructions())
+	c.changeOperand(exitPos, endPos)
+	c.patchLoop(loop, continuePos, breakPos)
+
+	return nil
+}
+
+func (c *Compiler) compileWhileRacingStatement(node *WhileRacingStatement) error {
+	conditionPos := len(c.currentInstructions())
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	exitPos := c.emit(OpJumpNotTruthy, 9999)
+
+	loop := c.enterLoop(node.Label)
+	if err := c.Compile(node.Body); err != nil {
+		return err
+	}
+	c.leaveLoop()
+
+	c.emit(OpJump, conditionPos)
+
+	endPos := len(c.currentInstructions())
+	c.changeOperand(exitPos, endPos)
+	c.patchLoop(loop, conditionPos, endPos)
+
+	return nil
+}
+
+func (c *Compiler) compileFunction(name string, parameters []*Identifier, body *BlockStatement) error {
+	c.enterScope()
+
+	params := []string{}
+	for _, p := range parameters {
+		c.symbolTable.Define(p.Value)
+		params = append(params, p.Value)
+	}
+	c.declareNames(body.Statements)
+
+	// Like the interpreter, a pace without return_pit yields the value of
+	// its final statement.
+	for idx, s := range body.Statements {
+		if idx < len(body.Statements)-1 {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.compileFinalStatement(s); err != nil {
+			return err
+		}
+	}
+	c.emit(OpReturn)
+
+	slotNames := c.symbolTable.SlotNames()
+	positions := c.scopes[c.scopeIndex].positions
+	instructions := c.leaveScope()
+
+	fn := &CompiledFunction{
+		Name:          name,
+		Instructions:  instructions,
+		Positions:     positions,
+		NumParameters: len(parameters),
+		SlotNames:     slotNames,
+		Parameters:    params,
+		Body:          body.String(),
+		File:          c.file,
+	}
+	c.emit(OpClosure, c.addConstant(fn))
+
+	return nil
+}
+
+// declareNames declares the names a scope's statements define before any
+// of them is compiled, so paces in the scope can call each other whatever
+// order they are written in.
+func (c *Compiler) declareNames(statements []Statement) {
+	for _, s := range statements {
+		switch s := s.(type) {
+		case *GridStatement:
+			c.symbolTable.Declare(s.Name.Value)
+		case *PaceStatement:
+			c.symbolTable.Declare(s.Name.Value)
+		case *GarageStatement:
+			c.symbolTable.Declare(s.Name.Value)
+		case *ImportStatement:
+			c.symbolTable.Declare(s.Alias.Value)
+		}
+	}
+}
+
+// compileModule compiles program as an imported file. Its top-level names
+// live in a scope of the module's own rather than among the globals, since
+// the paces it exports keep using them once called from the importing
+// program. The module returned holds that scope, for the VM to run the
+// file in.
+func (c *Compiler) compileModule(program *Program) (*Module, error) {
+	c.symbolTable = NewFunctionSymbolTable(c.symbolTable)
+	c.declareNames(program.Statements)
+	if err := c.Compile(program); err != nil {
+		return nil, err
+	}
+
+	slotNames := c.symbolTable.SlotNames()
+	module := &Module{
+		scope:   &Scope{Slots: make([]Object, len(slotNames)), Names: slotNames},
+		members: make(map[string]int),
+	}
+	for name, symbol := range c.symbolTable.store {
+		module.members[name] = symbol.Index
+	}
+	return module, nil
+}
+
+func (c *Compiler) compileFinalStatement(s Statement) error {
+	switch s := s.(type) {
+	case *ExpressionStatement:
+		if err := c.Compile(s.Expression); err != nil {
+			return err
+		}
+		c.emit(OpReturnValue)
+	case *GridStatement:
+		if err := c.Compile(s); err != nil {
+			return err
+		}
+		c.emitGet(c.symbolTable.Define(s.Name.Value))
+		c.emit(OpReturnValue)
+	default:
+		return c.Compile(s)
+	}
+	return nil
+}
+
+func (c *Compiler) emitGet(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(OpGetLocal, s.Index)
+	case OuterScope:
+		c.emit(OpGetOuter, s.Depth, s.Index)
+	case BuiltinScope:
+		c.emit(OpGetBuiltin, s.Index)
+	}
+}
+
+func (c *Compiler) emitSet(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(OpSetLocal, s.Index)
+	case OuterScope:
+		c.emit(OpSetOuter, s.Depth, s.Index)
+	}
+}
+
+// emitAssign stores the value on top of the stack in the existing binding
+// of name. A builtin is rebound at the top level, where the interpreter
+// keeps them. Names not declared yet are left to the VM, which fails if
+// there is still no such global when the assignment runs.
+func (c *Compiler) emitAssign(name string) {
+	symbol, ok := c.symbolTable.Resolve(name)
+	switch {
+	case !ok:
+		c.emit(OpSetName, c.addConstant(&String{Value: name}))
+	case symbol.Scope == BuiltinScope:
+		c.emitSet(c.symbolTable.Root().Define(name))
+	default:
+		c.emitSet(symbol)
+	}
+}
+
+func (c *Compiler) addConstant(obj Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op Opcode, operands ...int) int {
+	ins := MakeInstruction(op, operands...)
+	pos := len(c.currentInstructions())
+	scope := &c.scopes[c.scopeIndex]
+	scope.instructions = append(scope.instructions, ins...)
+	if n := len(scope.positions); n == 0 || scope.positions[n-1].Pos != c.pos {
+		scope.positions = append(scope.positions, SourceMapEntry{Offset: pos, Pos: c.pos})
+	}
+	return pos
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := Opcode(c.currentInstructions()[opPos])
+	copy(c.currentInstructions()[opPos:], MakeInstruction(op, operand))
+}
+
+func (c *Compiler) currentInstructions() Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+// targetLoop finds the loop a break_flag or continue_race with the given
+// label jumps to: the innermost one when it has no label.
+func (c *Compiler) targetLoop(label *Identifier) *loopJumps {
+	loops := c.scopes[c.scopeIndex].loops
+	for i := len(loops) - 1; i >= 0; i-- {
+		if label == nil || loops[i].label == label.Value {
+			return loops[i]
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) enterLoop(label *Identifier) *loopJumps {
+	scope := c.scopes[c.scopeIndex]
+	loop := &loopJumps{label: labelName(label), handlers: scope.handlers, scopes: scope.scopes}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, loop)
+	return loop
+}
+
+func (c *Compiler) leaveLoop() {
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+}
+
+// popHandlers drops the handlers of the safety_car bodies a break_flag or
+// continue_race jumps out of.
+func (c *Compiler) popHandlers(loop *loopJumps) {
+	for n := loop.handlers; n < c.scopes[c.scopeIndex].handlers; n++ {
+		c.emit(OpPopHandler)
+	}
+}
+
+// leaveScopes closes the block and loop-in scopes a break_flag or continue_race
+// jumps out of. Those of the loop it jumps to are closed where it lands.
+func (c *Compiler) leaveScopes(loop *loopJumps) {
+	for n := loop.scopes; n < c.scopes[c.scopeIndex].scopes; n++ {
+		c.emit(OpLeaveScope)
+	}
+}
+
+func (c *Compiler) patchLoop(loop *loopJumps, continuePos, breakPos int) {
+	for _, pos := range loop.continues {
+		c.changeOperand(pos, continuePos)
+	}
+	for _, pos := range loop.breaks {
+		c.changeOperand(pos, breakPos)
+	}
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{instructions: Instructions{}})
+	c.scopeIndex++
+	c.symbolTable = NewFunctionSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() Instructions {
+	instructions := c.currentInstructions()
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return instructions
+}
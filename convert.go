@@ -0,0 +1,361 @@
+package alonso
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+var (
+	objectType = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// ToObject converts a Go value to an Alonso object. Objects are returned as
+// they are and nil becomes null. Booleans, strings and all numeric kinds map
+// to their Alonso counterparts, slices and arrays to arrays, maps with
+// string, numeric or boolean keys to maps, and functions to builtins as
+// NewBuiltin describes. Pointers are followed.
+func ToObject(v interface{}) (Object, error) {
+	return toObject(reflect.ValueOf(v))
+}
+
+func toObject(v reflect.Value) (Object, error) {
+	if !v.IsValid() {
+		return NULL, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+	}
+
+	if v.Type().Implements(objectType) {
+		return v.Interface().(Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return toObject(v.Elem())
+	case reflect.Bool:
+		return nativeBoolToBooleanObject(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return newInteger(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return &Number{Value: float64(v.Uint())}, nil
+		}
+		return newInteger(int64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return &Number{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]Object, v.Len())
+		for idx := range elements {
+			el, err := toObject(v.Index(idx))
+			if err != nil {
+				return nil, err
+			}
+			elements[idx] = el
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		return mapToObject(v)
+	case reflect.Func:
+		return newHostBuiltin("host function", v)
+	default:
+		return nil, fmt.Errorf("cannot convert Go %s to an Alonso value", v.Type())
+	}
+}
+
+func mapToObject(v reflect.Value) (Object, error) {
+	type pair struct {
+		key Hashable
+		val Object
+	}
+
+	pairs := []pair{}
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := toObject(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		hashable, ok := key.(Hashable)
+		if !ok {
+			return nil, fmt.Errorf("cannot use Go %s as a map key", iter.Key().Type())
+		}
+		val, err := toObject(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair{hashable, val})
+	}
+
+	// Go maps are unordered; sort so the result inspects the same every time.
+	sort.Slice(pairs, func(a, b int) bool {
+		return pairs[a].key.Inspect() < pairs[b].key.Inspect()
+	})
+
+	m := NewMap()
+	for _, p := range pairs {
+		m.Set(p.key, p.val)
+	}
+	return m, nil
+}
+
+// FromObject converts an Alonso object to its natural Go value: null to nil,
+// integers to int64, floats to float64, strings to string, booleans to
+// bool, arrays to []interface{}, maps to map[interface{}]interface{} and
+// structs to map[string]interface{} of their fields. Other objects, such as
+// functions, are returned as they are. A container that holds itself
+// becomes a Go value that holds itself.
+func FromObject(obj Object) interface{} {
+	return fromObjectSeen(obj, nil)
+}
+
+// fromObjectSeen converts obj as FromObject does. seen maps the containers
+// converted so far to their Go values, so a repeat reuses its value instead
+// of recursing forever.
+func fromObjectSeen(obj Object, seen map[Object]interface{}) interface{} {
+	if v, ok := seen[obj]; ok {
+		return v
+	}
+
+	switch obj := obj.(type) {
+	case nil, *Null:
+		return nil
+	case *Integer:
+		return obj.Value
+	case *Number:
+		return obj.Value
+	case *String:
+		return obj.Value
+	case *Boolean:
+		return obj.Value
+	case *Array:
+		elements := make([]interface{}, len(obj.Elements))
+		seen = markConverted(seen, obj, elements)
+		for idx, el := range obj.Elements {
+			elements[idx] = fromObjectSeen(el, seen)
+		}
+		return elements
+	case *Map:
+		m := make(map[interface{}]interface{}, len(obj.Pairs))
+		seen = markConverted(seen, obj, m)
+		for _, entry := range obj.Entries() {
+			m[fromObjectSeen(entry.Key, seen)] = fromObjectSeen(entry.Value, seen)
+		}
+		return m
+	case *Struct:
+		fields := make(map[string]interface{}, len(obj.Fields))
+		seen = markConverted(seen, obj, fields)
+		for name, val := range obj.Fields {
+			fields[name] = fromObjectSeen(val, seen)
+		}
+		return fields
+	default:
+		return obj
+	}
+}
+
+func markConverted(seen map[Object]interface{}, obj Object, v interface{}) map[Object]interface{} {
+	if seen == nil {
+		seen = make(map[Object]interface{})
+	}
+	seen[obj] = v
+	return seen
+}
+
+// fromObject converts obj to a value of Go type t.
+func fromObject(obj Object, t reflect.Type) (reflect.Value, error) {
+	if objectType.AssignableTo(t) || reflect.TypeOf(obj).AssignableTo(t) && t.Kind() == reflect.Interface {
+		return reflect.ValueOf(&obj).Elem(), nil
+	}
+
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		natural := FromObject(obj)
+		if natural == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(natural), nil
+	}
+
+	if obj == NULL {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			return reflect.Zero(t), nil
+		}
+	}
+
+	mismatch := fmt.Errorf("cannot use %s (%s) as Go %s", obj.Inspect(), obj.Type(), t)
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := obj.(*Boolean); ok {
+			return reflect.ValueOf(b.Value).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := wholeNumber(obj); ok {
+			v := reflect.New(t).Elem()
+			if !v.OverflowInt(i) {
+				v.SetInt(i)
+				return v, nil
+			}
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := obj.(*Number); ok && n.Value == math.Trunc(n.Value) && n.Value >= math.MaxInt64 && n.Value < math.MaxUint64 {
+			v := reflect.New(t).Elem()
+			if !v.OverflowUint(uint64(n.Value)) {
+				v.SetUint(uint64(n.Value))
+				return v, nil
+			}
+		}
+		if i, ok := wholeNumber(obj); ok && i >= 0 {
+			v := reflect.New(t).Elem()
+			if !v.OverflowUint(uint64(i)) {
+				v.SetUint(uint64(i))
+				return v, nil
+			}
+		}
+	case reflect.Float32, reflect.Float64:
+		if isNumeric(obj) {
+			return reflect.ValueOf(toFloat(obj)).Convert(t), nil
+		}
+	case reflect.String:
+		if s, ok := obj.(*String); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+	case reflect.Slice:
+		if arr, ok := obj.(*Array); ok {
+			v := reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+			for idx, el := range arr.Elements {
+				converted, err := fromObject(el, t.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				v.Index(idx).Set(converted)
+			}
+			return v, nil
+		}
+	case reflect.Array:
+		if arr, ok := obj.(*Array); ok && len(arr.Elements) == t.Len() {
+			v := reflect.New(t).Elem()
+			for idx, el := range arr.Elements {
+				converted, err := fromObject(el, t.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				v.Index(idx).Set(converted)
+			}
+			return v, nil
+		}
+	case reflect.Map:
+		if m, ok := obj.(*Map); ok {
+			v := reflect.MakeMapWithSize(t, len(m.Pairs))
+			for _, entry := range m.Entries() {
+				key, err := fromObject(entry.Key, t.Key())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				val, err := fromObject(entry.Value, t.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				v.SetMapIndex(key, val)
+			}
+			return v, nil
+		}
+	case reflect.Ptr:
+		elem, err := fromObject(obj, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(t.Elem())
+		v.Elem().Set(elem)
+		return v, nil
+	}
+
+	return reflect.Value{}, mismatch
+}
+
+// NewBuiltin wraps the Go function fn so Alonso code can call it as name.
+// Arguments are converted to fn's parameter types, and fn may be variadic.
+// fn may return nothing, a value, an error, or a value and an error; the
+// value is converted with ToObject and a non-nil error becomes a runtime
+// error that recover can catch. A func(...Object) Object is used as is.
+func NewBuiltin(name string, fn interface{}) (*Builtin, error) {
+	if native, ok := fn.(func(args ...Object) Object); ok {
+		return &Builtin{Fn: native}, nil
+	}
+
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("%s: expected a function, got %T", name, fn)
+	}
+	return newHostBuiltin(name, v)
+}
+
+func newHostBuiltin(name string, fn reflect.Value) (*Builtin, error) {
+	t := fn.Type()
+
+	switch {
+	case t.NumOut() > 2,
+		t.NumOut() == 2 && t.Out(1) != errorType:
+		return nil, fmt.Errorf("%s: results must be (), (T), (error) or (T, error), got %s", name, t)
+	}
+
+	paramType := func(idx int) reflect.Type {
+		if t.IsVariadic() && idx >= t.NumIn()-1 {
+			return t.In(t.NumIn() - 1).Elem()
+		}
+		return t.In(idx)
+	}
+
+	return &Builtin{Fn: func(args ...Object) (result Object) {
+		if t.IsVariadic() {
+			if len(args) < t.NumIn()-1 {
+				return newError("wrong number of arguments. got=%d, want at least %d", len(args), t.NumIn()-1)
+			}
+		} else if len(args) != t.NumIn() {
+			return newError("wrong number of arguments. got=%d, want=%d", len(args), t.NumIn())
+		}
+
+		in := make([]reflect.Value, len(args))
+		for idx, arg := range args {
+			converted, err := fromObject(arg, paramType(idx))
+			if err != nil {
+				return newError("argument %d to `%s`: %s", idx+1, name, err)
+			}
+			in[idx] = converted
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				result = newError("`%s` panicked: %v", name, r)
+			}
+		}()
+
+		out := fn.Call(in)
+
+		if n := len(out); n > 0 && t.Out(n-1) == errorType {
+			if err, _ := out[n-1].Interface().(error); err != nil {
+				return newError("%s", err)
+			}
+			out = out[:n-1]
+		}
+		if len(out) == 0 {
+			return NULL
+		}
+
+		obj, err := toObject(out[0])
+		if err != nil {
+			return newError("result of `%s`: %s", name, err)
+		}
+		return obj
+	}}, nil
+}
@@ -0,0 +1,47 @@
+// Package alonso implements the Alonso programming language. Programs run on
+// either an Interpreter, which walks the syntax tree, or a Machine, which
+// compiles to bytecode for the VM. Both implement Backend, through which a
+// Go program can expose functions and values to Alonso code and call back
+// into it.
+package alonso
+
+import (
+	"context"
+	"fmt"
+)
+
+// Backend runs Alonso source. Interpreter walks the AST directly; Machine
+// compiles to bytecode for the VM.
+type Backend interface {
+	Execute(input string) error
+	ExecuteFile(filename, input string) error
+	ExecuteContext(ctx context.Context, input string) error
+	ExecuteFileContext(ctx context.Context, filename, input string) error
+
+	// Register makes the Go function fn callable as name; see NewBuiltin.
+	Register(name string, fn interface{}) error
+	// SetGlobal binds name to value, converted with ToObject.
+	SetGlobal(name string, value interface{}) error
+	// Global returns the value bound to name at the top level.
+	Global(name string) (Object, bool)
+	// Call calls the global function name with args converted by ToObject.
+	Call(name string, args ...interface{}) (Object, error)
+	CallContext(ctx context.Context, name string, args ...interface{}) (Object, error)
+
+	IO() *Streams
+	Resources() *Limits
+	Restrictions() *Sandbox
+}
+
+// toObjects converts the arguments of a Call.
+func toObjects(args []interface{}) ([]Object, error) {
+	objects := make([]Object, len(args))
+	for idx, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %s", idx+1, err)
+		}
+		objects[idx] = obj
+	}
+	return objects, nil
+}
@@ -0,0 +1,305 @@
+package alonso
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// backends returns a fresh interpreter and VM with output captured in out.
+func backends(out *bytes.Buffer) []Backend {
+	list := []Backend{NewInterpreter(), NewMachine()}
+	for _, backend := range list {
+		backend.IO().Stdout = out
+		backend.IO().Stderr = out
+	}
+	return list
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		fn      interface{}
+		input   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "typed arguments and result",
+			fn:    func(a, b int) int { return a + b },
+			input: `telemetry(host(2, 3))`,
+			want:  "5\n",
+		},
+		{
+			name:  "variadic",
+			fn:    func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+			input: `telemetry(host("-", "a", "b", "c"), host("-"))`,
+			want:  "a-b-c \n",
+		},
+		{
+			name: "collections",
+			fn: func(laps []float64, drivers map[string]bool) []string {
+				return []string{fmt.Sprint(laps), fmt.Sprint(drivers)}
+			},
+			input: `telemetry(host([1, 2.5], {"alonso": true}))`,
+			want:  "[[1 2.5], map[alonso:true]]\n",
+		},
+		{
+			name:  "no result is null",
+			fn:    func() {},
+			input: `telemetry(host())`,
+			want:  "null\n",
+		},
+		{
+			name:  "raw objects",
+			fn:    func(args ...Object) Object { return &Number{Value: float64(len(args))} },
+			input: `telemetry(host(1, "two", [3]))`,
+			want:  "3\n",
+		},
+		{
+			name:  "go errors are recoverable",
+			fn:    func(lap int) (int, error) { return 0, errors.New("engine failure") },
+			input: `safety_car { host(1) } recover (err) { telemetry("caught:", err.message) }`,
+			want:  "caught: engine failure\n",
+		},
+		{
+			name:    "go errors fail the program",
+			fn:      func() error { return errors.New("engine failure") },
+			input:   `host()`,
+			wantErr: "<input>:1:1: engine failure",
+		},
+		{
+			name:    "argument of the wrong type",
+			fn:      func(lap int) int { return lap },
+			input:   `host(1.5)`,
+			wantErr: "argument 1 to `host`: cannot use 1.5 (NUMBER) as Go int",
+		},
+		{
+			name:    "wrong number of arguments",
+			fn:      func(a, b int) int { return a },
+			input:   `host(1)`,
+			wantErr: "wrong number of arguments. got=1, want=2",
+		},
+		{
+			name:    "panics become errors",
+			fn:      func() { panic("blown tyre") },
+			input:   `host()`,
+			wantErr: "`host` panicked: blown tyre",
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		for _, backend := range backends(&out) {
+			t.Run(fmt.Sprintf("%s/%T", tt.name, backend), func(t *testing.T) {
+				out.Reset()
+				if err := backend.Register("host", tt.fn); err != nil {
+					t.Fatalf("Register: %v", err)
+				}
+
+				err := backend.Execute(tt.input)
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("error = %v, want %q", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Execute: %v", err)
+				}
+				if got := out.String(); got != tt.want {
+					t.Errorf("output = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestRegisterRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   interface{}
+		want string
+	}{
+		{"grid", func() {}, `invalid name "grid"`},
+		{"two words", func() {}, `invalid name "two words"`},
+		{"host", 42, "host: expected a function, got int"},
+		{"host", func() (int, int) { return 0, 0 }, "host: results must be"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		for _, backend := range backends(&out) {
+			err := backend.Register(tt.name, tt.fn)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%T Register(%q) error = %v, want %q", backend, tt.name, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestGlobalsAndCall(t *testing.T) {
+	var out bytes.Buffer
+	for _, backend := range backends(&out) {
+		t.Run(fmt.Sprintf("%T", backend), func(t *testing.T) {
+			out.Reset()
+
+			if err := backend.SetGlobal("team", map[string]interface{}{"name": "Aston Martin", "laps": []int{1, 2}}); err != nil {
+				t.Fatalf("SetGlobal: %v", err)
+			}
+			program := `
+telemetry(team["name"], team["laps"])
+grid points = 32
+pace double(x) { return_pit x * 2 }
+`
+			if err := backend.Execute(program); err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+			if got, want := out.String(), "Aston Martin [1, 2]\n"; got != want {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+
+			points, ok := backend.Global("points")
+			if !ok || FromObject(points) != int64(32) {
+				t.Errorf("Global(points) = %v, %t, want 32", points, ok)
+			}
+			if _, ok := backend.Global("missing"); ok {
+				t.Errorf("Global(missing) found a value")
+			}
+			if _, ok := backend.Global("length"); !ok {
+				t.Errorf("Global(length) did not find the builtin")
+			}
+
+			result, err := backend.Call("double", 21)
+			if err != nil || FromObject(result) != int64(42) {
+				t.Errorf("Call(double, 21) = %v, %v, want 42", result, err)
+			}
+
+			if _, err := backend.Call("missing"); err == nil || err.Error() != "identifier not found: missing" {
+				t.Errorf("Call(missing) error = %v", err)
+			}
+			if _, err := backend.Call("double", struct{}{}); err == nil || !strings.Contains(err.Error(), "cannot convert Go struct {}") {
+				t.Errorf("Call(double, struct{}{}) error = %v", err)
+			}
+
+			var rt *RuntimeError
+			if _, err := backend.Call("double", "x"); !errors.As(err, &rt) || rt.Message != "type mismatch: STRING * INTEGER" {
+				t.Errorf("Call(double, x) error = %#v", err)
+			}
+		})
+	}
+}
+
+// TestImport checks that modules see the functions the host registers and
+// that their members follow the grids their paces change.
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	module := `
+grid stops = 0
+pace pit() {
+    stops = stops + 1
+    return_pit lap_time()
+}
+`
+	if err := os.WriteFile(filepath.Join(dir, "pits.alo"), []byte(module), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	program := `
+import "pits.alo"
+telemetry(pits.pit(), pits.stops)
+pace box() { return_pit pits.pit() }
+`
+
+	var out bytes.Buffer
+	for _, backend := range backends(&out) {
+		t.Run(fmt.Sprintf("%T", backend), func(t *testing.T) {
+			out.Reset()
+			if err := backend.Register("lap_time", func() float64 { return 90.8 }); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := backend.ExecuteFile(filepath.Join(dir, "main.alo"), program); err != nil {
+				t.Fatalf("ExecuteFile: %v", err)
+			}
+			if got, want := out.String(), "90.8 1\n"; got != want {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+
+			if _, err := backend.Call("box"); err != nil {
+				t.Fatalf("Call(box): %v", err)
+			}
+			if err := backend.Execute("telemetry(pits.stops)"); err != nil {
+				t.Fatalf("Execute: %v", err)
+			}
+			if got, want := out.String(), "90.8 1\n2\n"; got != want {
+				t.Errorf("output = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseErrorIsReturned(t *testing.T) {
+	var out bytes.Buffer
+	for _, backend := range backends(&out) {
+		err := backend.Execute("grid x 5")
+
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("%T error = %#v, want *ParseError", backend, err)
+		}
+		want := []string{"expected next token to be ASSIGN, got NUMBER instead"}
+		if !reflect.DeepEqual(parseErr.Errors, want) {
+			t.Errorf("%T errors = %q, want %q", backend, parseErr.Errors, want)
+		}
+	}
+}
+
+func TestConversion(t *testing.T) {
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{uint8(7), "7"},
+		{-2.5, "-2.5"},
+		{"box", "box"},
+		{[]interface{}{1, "a", nil}, "[1, a, null]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{map[int]string{2: "b", 1: "a"}, "{1: a, 2: b}"},
+		{&[]int{3}, "[3]"},
+	}
+
+	for _, tt := range tests {
+		obj, err := ToObject(tt.in)
+		if err != nil {
+			t.Errorf("ToObject(%#v) error: %v", tt.in, err)
+			continue
+		}
+		if got := obj.Inspect(); got != tt.want {
+			t.Errorf("ToObject(%#v) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := ToObject(map[[1]int]int{{1}: 1}); err == nil {
+		t.Errorf("ToObject with array keys did not fail")
+	}
+
+	obj, _ := ToObject(map[string]interface{}{"laps": []int{1, 2}, "pit": nil})
+	want := map[interface{}]interface{}{"laps": []interface{}{int64(1), int64(2)}, "pit": nil}
+	if got := FromObject(obj); !reflect.DeepEqual(got, want) {
+		t.Errorf("FromObject = %#v, want %#v", got, want)
+	}
+
+	cyclic := NewMap()
+	cyclic.Set(&String{Value: "self"}, cyclic)
+	got, ok := FromObject(cyclic).(map[interface{}]interface{})
+	if !ok || reflect.ValueOf(got["self"]).Pointer() != reflect.ValueOf(got).Pointer() {
+		t.Errorf("FromObject of a map holding itself did not hold itself")
+	}
+}
@@ -0,0 +1,199 @@
+package alonso
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TraceFrame is one entry of a traceback: a call made from Function at
+// File:Pos.
+type TraceFrame struct {
+	Function string
+	File     string
+	Pos      Position
+	Source   string // the calling source line, filled in for reporting
+}
+
+// incidentGarage describes the value a recover clause receives.
+var incidentGarage = &Garage{Name: "Incident", Fields: []string{"message", "file", "line", "column"}}
+
+// newIncident turns a caught error into a value scripts can inspect.
+func newIncident(err *Error) *Struct {
+	return &Struct{Garage: incidentGarage, Fields: map[string]Object{
+		"message": &String{Value: err.Message},
+		"file":    &String{Value: displayFile(err.File)},
+		"line":    newInteger(int64(err.Pos.Line)),
+		"column":  newInteger(int64(err.Pos.Column)),
+	}}
+}
+
+// incidentError recovers the error an incident was made from, so that
+// red_flag can raise it again from its original location.
+func incidentError(incident *Struct) *Error {
+	err := &Error{}
+	if message, ok := incident.Fields["message"].(*String); ok {
+		err.Message = message.Value
+	}
+	if file, ok := incident.Fields["file"].(*String); ok && file.Value != "<input>" {
+		err.File = file.Value
+	}
+	if line, ok := incident.Fields["line"]; ok && isNumeric(line) {
+		err.Pos.Line = int(toFloat(line))
+	}
+	if column, ok := incident.Fields["column"]; ok && isNumeric(column) {
+		err.Pos.Column = int(toFloat(column))
+	}
+	return err
+}
+
+// SyntaxError is one mistake found by the parser, located at the token
+// where it was noticed.
+type SyntaxError struct {
+	Message  string
+	Pos      Position
+	Expected []TokenType // tokens that would have been accepted there, if known
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Pos.Line, e.Pos.Column, e.Message)
+}
+
+// ParseError is returned by Execute when the program does not parse. Each
+// message has already been written to Stderr.
+type ParseError struct {
+	File        string
+	Errors      []string       // the message of each diagnostic
+	Diagnostics []*SyntaxError // one per mistake, in source order
+}
+
+// newParseError collects the errors of a parser that failed on file.
+func newParseError(file string, p *Parser) *ParseError {
+	return &ParseError{File: displayFile(file), Errors: p.Errors(), Diagnostics: p.Diagnostics()}
+}
+
+// Report writes each diagnostic to w as file:line:col: message.
+func (e *ParseError) Report(w io.Writer) {
+	for _, err := range e.Diagnostics {
+		fmt.Fprintf(w, "Parser error: %s:%s\n", e.File, err)
+	}
+}
+
+func (e *ParseError) Error() string {
+	return "parsing failed"
+}
+
+// RuntimeError is an *Error that escaped a program, located in the source
+// that raised it so it can be reported as file:line:col.
+type RuntimeError struct {
+	Message string
+	File    string
+	Line    int
+	Column  int
+	Source  string       // the offending source line, if known
+	Trace   []TraceFrame // calls leading to the error, outermost first
+	Cause   error        // a limit error or the context's error; see Limits
+}
+
+// newRuntimeError locates err using sources, the program text keyed by
+// file name.
+func newRuntimeError(err *Error, sources map[string]string) *RuntimeError {
+	rt := &RuntimeError{
+		Message: err.Message,
+		File:    displayFile(err.File),
+		Line:    err.Pos.Line,
+		Column:  err.Pos.Column,
+		Source:  sourceLine(sources[err.File], err.Pos.Line),
+		Cause:   err.Cause,
+	}
+
+	for _, frame := range err.Trace {
+		if frame.Pos.Line == 0 {
+			continue // a call made from Go
+		}
+		frame.Source = sourceLine(sources[frame.File], frame.Pos.Line)
+		frame.File = displayFile(frame.File)
+		rt.Trace = append(rt.Trace, frame)
+	}
+
+	return rt
+}
+
+func displayFile(file string) string {
+	if file == "" {
+		return "<input>"
+	}
+	return file
+}
+
+func sourceLine(source string, line int) string {
+	lines := strings.Split(source, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line-1], "\r")
+}
+
+// Unwrap returns Cause, so errors.Is(err, ErrStepLimit) and
+// errors.Is(err, context.DeadlineExceeded) identify why a program stopped.
+func (e *RuntimeError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+
+	msg := fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+	source := []rune(e.Source)
+	if e.Source == "" || e.Column < 1 || e.Column > len(source)+1 {
+		return msg
+	}
+
+	// Columns count characters. Keep tabs in the caret line so it stays
+	// aligned with the source.
+	caret := []byte{}
+	for _, ch := range source[:e.Column-1] {
+		if ch == '\t' {
+			caret = append(caret, '\t')
+		} else {
+			caret = append(caret, ' ')
+		}
+	}
+
+	return fmt.Sprintf("%s\n    %s\n    %s^", msg, e.Source, caret)
+}
+
+// Traceback renders the calls that led to the error, most recent last.
+// Runs of identical frames, as produced by deep recursion, are collapsed.
+func (e *RuntimeError) Traceback() string {
+	if len(e.Trace) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	out.WriteString("Traceback (most recent call last):\n")
+
+	repeated := 0
+	for idx, frame := range e.Trace {
+		if idx >= 3 && frame == e.Trace[idx-1] && frame == e.Trace[idx-2] && frame == e.Trace[idx-3] {
+			repeated++
+			continue
+		}
+		if repeated > 0 {
+			fmt.Fprintf(&out, "  [previous frame repeated %d more times]\n", repeated)
+			repeated = 0
+		}
+
+		fmt.Fprintf(&out, "  %s:%d:%d in %s\n", frame.File, frame.Pos.Line, frame.Pos.Column, frame.Function)
+		if frame.Source != "" {
+			fmt.Fprintf(&out, "    %s\n", strings.TrimSpace(frame.Source))
+		}
+	}
+	if repeated > 0 {
+		fmt.Fprintf(&out, "  [previous frame repeated %d more times]\n", repeated)
+	}
+
+	return out.String()
+}
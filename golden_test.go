@@ -0,0 +1,103 @@
+package alonso
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the .expected golden files")
+
+// goldenScripts lists the programs whose output is checked against a
+// .expected file beside them. *_test.alo files are run by TestScriptTests.
+func goldenScripts(t *testing.T) []string {
+	t.Helper()
+
+	scripts := []string{}
+	for _, dir := range []string{"examples", "tests"} {
+		matches, err := filepath.Glob(filepath.Join(dir, "*.alo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range matches {
+			if !strings.HasSuffix(path, "_test.alo") {
+				scripts = append(scripts, path)
+			}
+		}
+	}
+	return scripts
+}
+
+func goldenPath(script string) string {
+	return strings.TrimSuffix(script, ".alo") + ".expected"
+}
+
+// runScript runs a program the way `alonso <file>` does and returns what
+// it prints, including the error report.
+func runScript(t *testing.T, backend Backend, script string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	backend.IO().Stdout = &out
+	backend.IO().Stderr = &out
+
+	err = backend.ExecuteFile(script, string(content))
+	var parseErr *ParseError
+	if err != nil && !errors.As(err, &parseErr) {
+		if rt, ok := err.(*RuntimeError); ok {
+			out.WriteString(rt.Traceback())
+		}
+		fmt.Fprintf(&out, "Runtime error: %v\n", err)
+	}
+	return out.String()
+}
+
+func TestGolden(t *testing.T) {
+	for _, script := range goldenScripts(t) {
+		t.Run(script, func(t *testing.T) {
+			got := runScript(t, NewInterpreter(), script)
+
+			if *update {
+				if err := os.WriteFile(goldenPath(script), []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(goldenPath(script))
+			if err != nil {
+				t.Fatalf("missing golden file, run go test -update: %v", err)
+			}
+			if got != string(want) {
+				t.Errorf("output mismatch\n--- got ---\n%s\n--- want ---\n%s", got, want)
+			}
+		})
+	}
+}
+
+// TestGoldenVM checks that the VM prints exactly what the interpreter does.
+func TestGoldenVM(t *testing.T) {
+	for _, script := range goldenScripts(t) {
+		t.Run(script, func(t *testing.T) {
+			want, err := os.ReadFile(goldenPath(script))
+			if err != nil {
+				t.Fatalf("missing golden file, run go test -update: %v", err)
+			}
+
+			got := runScript(t, NewMachine(), script)
+			if got != string(want) {
+				t.Errorf("output mismatch\n--- got ---\n%s\n--- want ---\n%s", got, want)
+			}
+		})
+	}
+}
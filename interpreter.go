@@ -1,7 +1,11 @@
-package main
+package alonso
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
 )
 
 var (
@@ -11,92 +15,172 @@ var (
 )
 
 type Interpreter struct {
+	Streams
+	Limits
+	Sandbox
+
 	env *Environment
+
+	builtins  *Environment      // outer scope of every imported module
+	file      string            // file being executed, for imports and errors
+	sources   map[string]string // source text by file, for error excerpts
+	modules   *moduleLoader     // imported modules
+	callStack []callFrame       // active pace calls, for tracebacks
+	meter     *meter            // resources used by the current run
+}
+
+type callFrame struct {
+	name string     // the pace being called
+	site TraceFrame // where it was called from
 }
 
 func NewInterpreter() *Interpreter {
 	env := NewEnvironment()
+	builtinEnv := NewEnvironment()
 
-	// Add built-in functions
-	builtins := map[string]*Builtin{
-		"telemetry": { // print function
-			Fn: func(args ...Object) Object {
-				for i, arg := range args {
-					if i > 0 {
-						fmt.Print(" ")
-					}
-					fmt.Print(arg.Inspect())
-				}
-				fmt.Println()
-				return NULL
-			},
-		},
-		"length": {
-			Fn: func(args ...Object) Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
-
-				switch arg := args[0].(type) {
-				case *Array:
-					return &Number{Value: float64(len(arg.Elements))}
-				case *String:
-					return &Number{Value: float64(len(arg.Value))}
-				default:
-					return newError("argument to `length` not supported, got %T", arg)
-				}
-			},
-		},
-		"push": {
-			Fn: func(args ...Object) Object {
-				if len(args) != 2 {
-					return newError("wrong number of arguments. got=%d, want=2", len(args))
-				}
-
-				if args[0].Type() != ARRAY_OBJ {
-					return newError("argument to `push` must be ARRAY, got %T", args[0])
-				}
-
-				arr := args[0].(*Array)
-				length := len(arr.Elements)
-
-				newElements := make([]Object, length+1)
-				copy(newElements, arr.Elements)
-				newElements[length] = args[1]
-
-				return &Array{Elements: newElements}
-			},
-		},
+	for _, def := range builtins {
+		env.Set(def.Name, def.Builtin)
+		builtinEnv.Set(def.Name, def.Builtin)
 	}
 
-	for name, builtin := range builtins {
-		env.Set(name, builtin)
+	return &Interpreter{
+		Streams:  newStreams(),
+		env:      env,
+		builtins: builtinEnv,
+		modules:  newModuleLoader(),
+		sources:  make(map[string]string),
+		meter:    newMeter(context.Background(), Limits{}),
 	}
+}
 
-	return &Interpreter{env: env}
+// ExecuteFile runs input as the contents of filename, so that imports in it
+// resolve relative to that file.
+func (i *Interpreter) ExecuteFile(filename, input string) error {
+	return i.ExecuteFileContext(context.Background(), filename, input)
+}
+
+// ExecuteFileContext is ExecuteFile, stopping with a runtime error once ctx
+// is done.
+func (i *Interpreter) ExecuteFileContext(ctx context.Context, filename, input string) error {
+	previous := i.file
+	i.file = filename
+	defer func() { i.file = previous }()
+
+	return i.ExecuteContext(ctx, input)
 }
 
 func (i *Interpreter) Execute(input string) error {
+	return i.ExecuteContext(context.Background(), input)
+}
+
+// ExecuteContext is Execute, stopping with a runtime error once ctx is done.
+func (i *Interpreter) ExecuteContext(ctx context.Context, input string) error {
 	lexer := NewLexer(input)
 	parser := NewParser(lexer)
 	program := parser.ParseProgram()
 
 	if len(parser.Errors()) > 0 {
-		for _, err := range parser.Errors() {
-			fmt.Printf("Parser error: %s\n", err)
-		}
-		return fmt.Errorf("parsing failed")
+		err := newParseError(i.file, parser)
+		err.Report(i.Stderr)
+		return err
 	}
 
+	i.sources[i.file] = input
+	i.meter = newMeter(ctx, i.Limits)
+
 	result := i.Eval(program, i.env)
-	if result != nil && result.Type() == ERROR_OBJ {
-		return fmt.Errorf(result.Inspect())
+	if err, ok := result.(*Error); ok {
+		return newRuntimeError(err, i.sources)
 	}
 
 	return nil
 }
 
+// Call calls the global function name with args, as if from the top level
+// of the program.
+func (i *Interpreter) Call(name string, args ...interface{}) (Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is Call, stopping with a runtime error once ctx is done.
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...interface{}) (Object, error) {
+	fn, ok := i.env.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("identifier not found: %s", name)
+	}
+
+	objects, err := toObjects(args)
+	if err != nil {
+		return nil, fmt.Errorf("calling %s: %s", name, err)
+	}
+
+	i.meter = newMeter(ctx, i.Limits)
+	result := i.callFunction(fn, objects, Position{})
+	if err, ok := result.(*Error); ok {
+		return nil, newRuntimeError(err, i.sources)
+	}
+	return result, nil
+}
+
+// Register makes fn callable from Alonso code as name, in the program and
+// in every module it imports. See NewBuiltin for how values are converted.
+func (i *Interpreter) Register(name string, fn interface{}) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+
+	builtin, err := NewBuiltin(name, fn)
+	if err != nil {
+		return err
+	}
+
+	i.env.Set(name, builtin)
+	i.builtins.Set(name, builtin)
+	return nil
+}
+
+// SetGlobal binds name to value in the top-level scope.
+func (i *Interpreter) SetGlobal(name string, value interface{}) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+
+	obj, err := ToObject(value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	i.env.Set(name, obj)
+	return nil
+}
+
+// Global returns the value of name in the top-level scope.
+func (i *Interpreter) Global(name string) (Object, bool) {
+	return i.env.Get(name)
+}
+
+// Eval evaluates node and, if it produced an error that has no location
+// yet, attributes the error to node. Errors are located by the innermost
+// node that raised them.
 func (i *Interpreter) Eval(node Node, env *Environment) Object {
+	var result Object
+	if err := i.meter.step(); err != nil {
+		result = err
+	} else {
+		result = i.eval(node, env)
+	}
+
+	if err, ok := result.(*Error); ok && err.Pos.Line == 0 {
+		if pos := node.Pos(); pos.Line > 0 {
+			err.Pos = pos
+			err.File = i.file
+		}
+	}
+
+	return result
+}
+
+func (i *Interpreter) eval(node Node, env *Environment) Object {
 	switch node := node.(type) {
 
 	// Statements
@@ -113,19 +197,55 @@ func (i *Interpreter) Eval(node Node, env *Environment) Object {
 
 	case *PaceStatement:
 		fn := &Function{
+			Name:       node.Name.Value,
 			Parameters: node.Parameters,
 			Body:       node.Body,
 			Env:        env,
+			File:       i.file,
 		}
 		env.Set(node.Name.Value, fn)
 		return fn
 
+	case *PaceLiteral:
+		return &Function{
+			Name:       functionName(node),
+			Parameters: node.Parameters,
+			Body:       node.Body,
+			Env:        env,
+			File:       i.file,
+		}
+
+	case *GarageStatement:
+		garage := &Garage{Name: node.Name.Value}
+		for _, field := range node.Fields {
+			garage.Fields = append(garage.Fields, field.Value)
+		}
+		env.Set(node.Name.Value, garage)
+		return garage
+
+	case *ImportStatement:
+		module := i.evalImportStatement(node)
+		if isError(module) {
+			return module
+		}
+		env.Set(node.Alias.Value, module)
+		return module
+
 	case *CircuitStatement:
 		return i.evalCircuitStatement(node, env)
 
+	case *StrategyStatement:
+		return i.evalStrategyStatement(node, env)
+
 	case *LoopStatement:
 		return i.evalLoopStatement(node, env)
 
+	case *LoopInStatement:
+		return i.evalLoopInStatement(node, env)
+
+	case *SafetyCarStatement:
+		return i.evalSafetyCarStatement(node, env)
+
 	case *WhileRacingStatement:
 		return i.evalWhileRacingStatement(node, env)
 
@@ -141,10 +261,10 @@ func (i *Interpreter) Eval(node Node, env *Environment) Object {
 		return &ReturnValue{Value: val}
 
 	case *BreakFlagStatement:
-		return &Break{}
+		return &Break{Label: labelName(node.Label)}
 
 	case *ContinueRaceStatement:
-		return &Continue{}
+		return &Continue{Label: labelName(node.Label)}
 
 	case *BlockStatement:
 		return i.evalBlockStatement(node, env)
@@ -153,21 +273,37 @@ func (i *Interpreter) Eval(node Node, env *Environment) Object {
 		return i.Eval(node.Expression, env)
 
 	// Expressions
+	case *IntegerLiteral:
+		return newInteger(node.Value)
+
 	case *NumberLiteral:
 		return &Number{Value: node.Value}
 
 	case *StringLiteral:
 		return &String{Value: node.Value}
 
+	case *InterpolatedString:
+		parts := i.evalExpressions(node.Parts, env)
+		if len(parts) == 1 && isError(parts[0]) {
+			return parts[0]
+		}
+		return i.meter.charge(interpolate(parts))
+
 	case *BooleanLiteral:
 		return nativeBoolToBooleanObject(node.Value)
 
+	case *MatchExpression:
+		return i.evalMatchExpression(node, env)
+
 	case *FormationLiteral:
 		elements := i.evalExpressions(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
 			return elements[0]
 		}
-		return &Array{Elements: elements}
+		return i.meter.charge(&Array{Elements: elements})
+
+	case *MapLiteral:
+		return i.meter.charge(i.evalMapLiteral(node, env))
 
 	case *IndexExpression:
 		left := i.Eval(node.Left, env)
@@ -178,7 +314,30 @@ func (i *Interpreter) Eval(node Node, env *Environment) Object {
 		if isError(index) {
 			return index
 		}
-		return i.evalIndexExpression(left, index)
+		return evalIndexExpression(left, index)
+
+	case *SliceExpression:
+		left := i.Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		bounds := []Object{NULL, NULL}
+		for n, bound := range []Expression{node.Start, node.End} {
+			if bound == nil {
+				continue
+			}
+			if bounds[n] = i.Eval(bound, env); isError(bounds[n]) {
+				return bounds[n]
+			}
+		}
+		return i.meter.charge(evalSliceExpression(left, bounds[0], bounds[1]))
+
+	case *MemberExpression:
+		object := i.Eval(node.Object, env)
+		if isError(object) {
+			return object
+		}
+		return evalMemberExpression(object, node.Property.Value)
 
 	case *Identifier:
 		return i.evalIdentifier(node, env)
@@ -188,7 +347,7 @@ func (i *Interpreter) Eval(node Node, env *Environment) Object {
 		if isError(right) {
 			return right
 		}
-		return i.evalPrefixExpression(node.Operator, right)
+		return evalPrefixExpression(node.Operator, right)
 
 	case *InfixExpression:
 		left := i.Eval(node.Left, env)
@@ -199,7 +358,7 @@ func (i *Interpreter) Eval(node Node, env *Environment) Object {
 		if isError(right) {
 			return right
 		}
-		return i.evalInfixExpression(node.Operator, left, right)
+		return i.meter.charge(evalInfixExpression(node.Operator, left, right))
 
 	case *CallExpression:
 		function := i.Eval(node.Function, env)
@@ -210,21 +369,102 @@ func (i *Interpreter) Eval(node Node, env *Environment) Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return i.applyFunction(function, args)
+		return i.callFunction(function, args, node.Pos())
 
 	case *AssignmentExpression:
 		val := i.Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
-		return val
+		return assign(env, node.Name.Value, val)
+
+	case *CompoundAssignmentExpression:
+		return i.evalCompoundAssignment(node, env)
+
+	case *FieldAssignmentExpression:
+		object := i.Eval(node.Target.Object, env)
+		if isError(object) {
+			return object
+		}
+		val := i.Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return evalFieldAssignment(object, node.Target.Property.Value, val)
+
+	case *IndexAssignmentExpression:
+		left := i.Eval(node.Target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := i.Eval(node.Target.Index, env)
+		if isError(index) {
+			return index
+		}
+		val := i.Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return i.meter.setIndex(left, index, val)
 
 	default:
 		return newError("unknown node type: %T", node)
 	}
 }
 
+// evalCompoundAssignment evaluates the parts of the target once, then
+// stores the result of applying the operator to its current value.
+func (i *Interpreter) evalCompoundAssignment(node *CompoundAssignmentExpression, env *Environment) Object {
+	apply := func(current Object) Object {
+		if isError(current) {
+			return current
+		}
+		val := i.Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return i.meter.charge(evalInfixExpression(node.Operator, current, val))
+	}
+
+	switch target := node.Target.(type) {
+	case *Identifier:
+		val := apply(i.evalIdentifier(target, env))
+		if isError(val) {
+			return val
+		}
+		return assign(env, target.Value, val)
+
+	case *IndexExpression:
+		left := i.Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := i.Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+		val := apply(evalIndexExpression(left, index))
+		if isError(val) {
+			return val
+		}
+		return i.meter.setIndex(left, index, val)
+
+	case *MemberExpression:
+		object := i.Eval(target.Object, env)
+		if isError(object) {
+			return object
+		}
+		val := apply(evalMemberExpression(object, target.Property.Value))
+		if isError(val) {
+			return val
+		}
+		return evalFieldAssignment(object, target.Property.Value, val)
+
+	default:
+		return newError("invalid assignment target: %T", target)
+	}
+}
+
 func (i *Interpreter) evalProgram(stmts []Statement, env *Environment) Object {
 	var result Object
 
@@ -242,9 +482,12 @@ func (i *Interpreter) evalProgram(stmts []Statement, env *Environment) Object {
 	return result
 }
 
+// evalBlockStatement runs block in a scope of its own, so names it
+// declares with grid are gone once it ends.
 func (i *Interpreter) evalBlockStatement(block *BlockStatement, env *Environment) Object {
 	var result Object
 
+	env = NewEnclosedEnvironment(env)
 	for _, statement := range block.Statements {
 		result = i.Eval(statement, env)
 
@@ -259,6 +502,42 @@ func (i *Interpreter) evalBlockStatement(block *BlockStatement, env *Environment
 	return result
 }
 
+func (i *Interpreter) evalImportStatement(node *ImportStatement) Object {
+	if i.DisableImports {
+		return newError("imports are disabled in this sandbox")
+	}
+
+	// Each module runs once, in its own environment, with the importing
+	// file's position restored afterwards.
+	return i.modules.load(i.file, node.Path, i.sources, func(file string, program *Program) Object {
+		previous := i.file
+		i.file = file
+		defer func() { i.file = previous }()
+
+		moduleEnv := NewEnclosedEnvironment(i.builtins)
+		if result := i.Eval(program, moduleEnv); isError(result) {
+			return result
+		}
+		return &Module{Env: moduleEnv}
+	})
+}
+
+func (i *Interpreter) evalSafetyCarStatement(node *SafetyCarStatement, env *Environment) Object {
+	result := i.Eval(node.Body, env)
+
+	err, ok := result.(*Error)
+	if !ok {
+		return result
+	}
+
+	// The incident is visible only to the recover block.
+	handlerEnv := NewEnclosedEnvironment(env)
+	if node.Param != nil {
+		handlerEnv.Set(node.Param.Value, newIncident(err))
+	}
+	return i.Eval(node.Handler, handlerEnv)
+}
+
 func (i *Interpreter) evalCircuitStatement(node *CircuitStatement, env *Environment) Object {
 	condition := i.Eval(node.Condition, env)
 	if isError(condition) {
@@ -267,15 +546,153 @@ func (i *Interpreter) evalCircuitStatement(node *CircuitStatement, env *Environm
 
 	if isTruthy(condition) {
 		return i.Eval(node.Consequence, env)
-	} else if node.Alternative != nil {
+	}
+
+	for _, branch := range node.ElseCircuits {
+		condition := i.Eval(branch.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if isTruthy(condition) {
+			return i.Eval(branch.Consequence, env)
+		}
+	}
+
+	if node.Alternative != nil {
 		return i.Eval(node.Alternative, env)
-	} else {
-		return NULL
 	}
+	return NULL
+}
+
+// evalStrategyStatement runs the first case that matches the value, trying
+// the values of each case in order and stopping at the first match.
+func (i *Interpreter) evalStrategyStatement(node *StrategyStatement, env *Environment) Object {
+	value := i.Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+
+	for _, c := range node.Cases {
+		for _, exp := range c.Values {
+			matched := i.matchCase(value, exp, env)
+			if isError(matched) {
+				return matched
+			}
+			if matched == TRUE {
+				return i.Eval(c.Body, env)
+			}
+		}
+	}
+
+	if node.Default != nil {
+		return i.Eval(node.Default, env)
+	}
+	return NULL
+}
+
+func (i *Interpreter) matchCase(value Object, exp Expression, env *Environment) Object {
+	rng, ok := exp.(*RangeExpression)
+	if !ok {
+		other := i.Eval(exp, env)
+		if isError(other) {
+			return other
+		}
+		return nativeBoolToBooleanObject(objectsEqual(value, other))
+	}
+
+	start := i.Eval(rng.Start, env)
+	if isError(start) {
+		return start
+	}
+	end := i.Eval(rng.End, env)
+	if isError(end) {
+		return end
+	}
+	return inCaseRange(value, start, end)
+}
+
+// evalMatchExpression evaluates the body of the first arm that matches, in
+// a scope holding the names its pattern binds, which its guard sees too.
+func (i *Interpreter) evalMatchExpression(node *MatchExpression, env *Environment) Object {
+	value := i.Eval(node.Value, env)
+	if isError(value) {
+		return value
+	}
+
+	for _, arm := range node.Arms {
+		garages, err := i.patternGarages(arm.Pattern, env)
+		if err != nil {
+			return err
+		}
+		bound, ok := matchPattern(arm.Pattern, value, garages)
+		if !ok {
+			continue
+		}
+
+		armEnv := NewEnclosedEnvironment(env)
+		for idx, name := range patternNames(arm.Pattern) {
+			armEnv.Set(name, bound[idx])
+		}
+
+		if arm.Guard != nil {
+			guard := i.Eval(arm.Guard, armEnv)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+
+		return i.Eval(arm.Body, armEnv)
+	}
+
+	return noMatchError(value)
+}
+
+// patternGarages looks up the garage each struct pattern in pattern names.
+func (i *Interpreter) patternGarages(pattern Pattern, env *Environment) (map[*StructPattern]*Garage, *Error) {
+	var garages map[*StructPattern]*Garage
+	for _, sp := range structPatterns(pattern) {
+		val := i.Eval(sp.Garage, env)
+		if err, ok := val.(*Error); ok {
+			return nil, err
+		}
+		garage, err := patternGarage(sp, val)
+		if err != nil {
+			return nil, err
+		}
+		if garages == nil {
+			garages = make(map[*StructPattern]*Garage)
+		}
+		garages[sp] = garage
+	}
+	return garages, nil
+}
+
+func noMatchError(value Object) *Error {
+	return newError("no match arm for %s", value.Inspect())
+}
+
+// inCaseRange reports whether value is a number from start up to and
+// including end. Values of other types are in no range.
+func inCaseRange(value, start, end Object) Object {
+	for _, bound := range []Object{start, end} {
+		if !isNumeric(bound) {
+			return newError("case bounds must be INTEGER or NUMBER, got %s", bound.Type())
+		}
+	}
+	if !isNumeric(value) {
+		return FALSE
+	}
+
+	low, high := compareNumbers(value, start), compareNumbers(value, end)
+	return nativeBoolToBooleanObject((low == 0 || low == 1) && (high == -1 || high == 0))
 }
 
 func (i *Interpreter) evalLoopStatement(node *LoopStatement, env *Environment) Object {
-	// Create new scope for loop
+	// Names declared in the header belong to the loop; the body gets a
+	// fresh scope inside it on every iteration.
 	loopEnv := NewEnclosedEnvironment(env)
 
 	// Initialize
@@ -307,9 +724,15 @@ func (i *Interpreter) evalLoopStatement(node *LoopStatement, env *Environment) O
 			case RETURN_OBJ, ERROR_OBJ:
 				return result
 			case BREAK_OBJ:
+				if !jumpsTo(result, node.Label) {
+					return result
+				}
 				return NULL
 			case CONTINUE_OBJ:
-				// Continue to update
+				if !jumpsTo(result, node.Label) {
+					return result
+				}
+				result = NULL
 			}
 		}
 
@@ -325,6 +748,68 @@ func (i *Interpreter) evalLoopStatement(node *LoopStatement, env *Environment) O
 	return result
 }
 
+// evalLoopInStatement runs the body once per element, binding the loop
+// names in a fresh scope each time, so that paces created in the body keep
+// the element of their own pass.
+func (i *Interpreter) evalLoopInStatement(node *LoopInStatement, env *Environment) Object {
+	var iter Object
+	if rng, ok := node.Iterable.(*RangeExpression); ok {
+		start := i.Eval(rng.Start, env)
+		if isError(start) {
+			return start
+		}
+		end := i.Eval(rng.End, env)
+		if isError(end) {
+			return end
+		}
+		iter = newRangeIterator(start, end)
+	} else {
+		iterable := i.Eval(node.Iterable, env)
+		if isError(iterable) {
+			return iterable
+		}
+		iter = newIterator(iterable, node.Key != nil)
+	}
+	if isError(iter) {
+		return iter
+	}
+
+	var result Object = NULL
+
+	for {
+		key, value, ok := iter.(*Iterator).Next()
+		if !ok {
+			break
+		}
+
+		loopEnv := NewEnclosedEnvironment(env)
+		if node.Key != nil {
+			loopEnv.Set(node.Key.Value, key)
+		}
+		loopEnv.Set(node.Value.Value, value)
+
+		result = i.Eval(node.Body, loopEnv)
+		if result != nil {
+			switch result.Type() {
+			case RETURN_OBJ, ERROR_OBJ:
+				return result
+			case BREAK_OBJ:
+				if !jumpsTo(result, node.Label) {
+					return result
+				}
+				return NULL
+			case CONTINUE_OBJ:
+				if !jumpsTo(result, node.Label) {
+					return result
+				}
+				result = NULL
+			}
+		}
+	}
+
+	return result
+}
+
 func (i *Interpreter) evalWhileRacingStatement(node *WhileRacingStatement, env *Environment) Object {
 	var result Object = NULL
 
@@ -344,9 +829,15 @@ func (i *Interpreter) evalWhileRacingStatement(node *WhileRacingStatement, env *
 			case RETURN_OBJ, ERROR_OBJ:
 				return result
 			case BREAK_OBJ:
+				if !jumpsTo(result, node.Label) {
+					return result
+				}
 				return NULL
 			case CONTINUE_OBJ:
-				continue
+				if !jumpsTo(result, node.Label) {
+					return result
+				}
+				result = NULL
 			}
 		}
 	}
@@ -354,18 +845,38 @@ func (i *Interpreter) evalWhileRacingStatement(node *WhileRacingStatement, env *
 	return result
 }
 
-func (i *Interpreter) evalPrefixExpression(operator string, right Object) Object {
+// jumpsTo reports whether a Break or Continue is for the loop with the
+// given label: unlabelled jumps are for the innermost loop.
+func jumpsTo(jump Object, label *Identifier) bool {
+	var target string
+	switch jump := jump.(type) {
+	case *Break:
+		target = jump.Label
+	case *Continue:
+		target = jump.Label
+	}
+	return target == "" || target == labelName(label)
+}
+
+func labelName(label *Identifier) string {
+	if label == nil {
+		return ""
+	}
+	return label.Value
+}
+
+func evalPrefixExpression(operator string, right Object) Object {
 	switch operator {
 	case "!":
-		return i.evalBangOperatorExpression(right)
+		return evalBangOperatorExpression(right)
 	case "-":
-		return i.evalMinusPrefixOperatorExpression(right)
+		return evalMinusPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%T", operator, right)
+		return newError("unknown operator: %s%s", operator, right.Type())
 	}
 }
 
-func (i *Interpreter) evalBangOperatorExpression(right Object) Object {
+func evalBangOperatorExpression(right Object) Object {
 	switch right {
 	case TRUE:
 		return FALSE
@@ -378,40 +889,79 @@ func (i *Interpreter) evalBangOperatorExpression(right Object) Object {
 	}
 }
 
-func (i *Interpreter) evalMinusPrefixOperatorExpression(right Object) Object {
-	if right.Type() != NUMBER_OBJ {
-		return newError("unknown operator: -%T", right)
+func evalMinusPrefixOperatorExpression(right Object) Object {
+	switch right := right.(type) {
+	case *Integer:
+		if right.Value == math.MinInt64 {
+			return newError("integer overflow: -(%d)", right.Value)
+		}
+		return newInteger(-right.Value)
+	case *Number:
+		return &Number{Value: -right.Value}
+	default:
+		return newError("unknown operator: -%s", right.Type())
 	}
+}
 
-	value := right.(*Number).Value
-	return &Number{Value: -value}
+// interpolate joins the evaluated parts of an interpolated string, showing
+// each value as telemetry would.
+func interpolate(parts []Object) *String {
+	var out strings.Builder
+	for _, part := range parts {
+		out.WriteString(part.Inspect())
+	}
+	return &String{Value: out.String()}
 }
 
-func (i *Interpreter) evalInfixExpression(operator string, left, right Object) Object {
+func evalInfixExpression(operator string, left, right Object) Object {
 	switch {
-	case left.Type() == NUMBER_OBJ && right.Type() == NUMBER_OBJ:
-		return i.evalNumberInfixExpression(operator, left, right)
+	case isNumeric(left) && isNumeric(right):
+		return evalNumberInfixExpression(operator, left, right)
 	case left.Type() == STRING_OBJ && right.Type() == STRING_OBJ:
-		return i.evalStringInfixExpression(operator, left, right)
+		return evalStringInfixExpression(operator, left, right)
 	case operator == "&&":
 		return nativeBoolToBooleanObject(isTruthy(left) && isTruthy(right))
 	case operator == "||":
 		return nativeBoolToBooleanObject(isTruthy(left) || isTruthy(right))
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(objectsEqual(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!objectsEqual(left, right))
 	case left.Type() != right.Type():
-		return newError("type mismatch: %T %s %T", left, operator, right)
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %T %s %T", left, operator, right)
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func (i *Interpreter) evalNumberInfixExpression(operator string, left, right Object) Object {
-	leftVal := left.(*Number).Value
-	rightVal := right.(*Number).Value
+// evalNumberInfixExpression applies operator to two numbers. Arithmetic on
+// two integers stays exact, except that / always divides as floats; an
+// integer meeting a float is promoted to a float.
+func evalNumberInfixExpression(operator string, left, right Object) Object {
+	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(compareNumbers(left, right) == -1)
+	case ">":
+		return nativeBoolToBooleanObject(compareNumbers(left, right) == 1)
+	case "<=":
+		c := compareNumbers(left, right)
+		return nativeBoolToBooleanObject(c == -1 || c == 0)
+	case ">=":
+		c := compareNumbers(left, right)
+		return nativeBoolToBooleanObject(c == 1 || c == 0)
+	case "==":
+		return nativeBoolToBooleanObject(compareNumbers(left, right) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(compareNumbers(left, right) != 0)
+	}
+
+	l, lInt := left.(*Integer)
+	r, rInt := right.(*Integer)
+	if lInt && rInt && operator != "/" {
+		return evalIntegerInfixExpression(operator, l.Value, r.Value)
+	}
 
+	leftVal, rightVal := toFloat(left), toFloat(right)
 	switch operator {
 	case "+":
 		return &Number{Value: leftVal + rightVal}
@@ -425,25 +975,47 @@ func (i *Interpreter) evalNumberInfixExpression(operator string, left, right Obj
 		}
 		return &Number{Value: leftVal / rightVal}
 	case "%":
-		return &Number{Value: float64(int(leftVal) % int(rightVal))}
-	case "<":
-		return nativeBoolToBooleanObject(leftVal < rightVal)
-	case ">":
-		return nativeBoolToBooleanObject(leftVal > rightVal)
-	case "<=":
-		return nativeBoolToBooleanObject(leftVal <= rightVal)
-	case ">=":
-		return nativeBoolToBooleanObject(leftVal >= rightVal)
-	case "==":
-		return nativeBoolToBooleanObject(leftVal == rightVal)
-	case "!=":
-		return nativeBoolToBooleanObject(leftVal != rightVal)
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return &Number{Value: math.Mod(leftVal, rightVal)}
 	default:
 		return newError("unknown operator: %s", operator)
 	}
 }
 
-func (i *Interpreter) evalStringInfixExpression(operator string, left, right Object) Object {
+// evalIntegerInfixExpression does integer arithmetic, failing rather than
+// wrapping around when the result does not fit in 64 bits.
+func evalIntegerInfixExpression(operator string, left, right int64) Object {
+	var result int64
+	switch operator {
+	case "+":
+		result = left + right
+		if (right > 0 && result < left) || (right < 0 && result > left) {
+			return newError("integer overflow: %d + %d", left, right)
+		}
+	case "-":
+		result = left - right
+		if (right > 0 && result > left) || (right < 0 && result < left) {
+			return newError("integer overflow: %d - %d", left, right)
+		}
+	case "*":
+		result = left * right
+		if left != 0 && (result/left != right || (left == -1 && right == math.MinInt64)) {
+			return newError("integer overflow: %d * %d", left, right)
+		}
+	case "%":
+		if right == 0 {
+			return newError("division by zero")
+		}
+		result = left % right
+	default:
+		return newError("unknown operator: %s", operator)
+	}
+	return newInteger(result)
+}
+
+func evalStringInfixExpression(operator string, left, right Object) Object {
 	leftVal := left.(*String).Value
 	rightVal := right.(*String).Value
 
@@ -455,22 +1027,181 @@ func (i *Interpreter) evalStringInfixExpression(operator string, left, right Obj
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %T %s %T", left, operator, right)
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func (i *Interpreter) evalIndexExpression(left, index Object) Object {
+func evalIndexExpression(left, index Object) Object {
 	switch {
-	case left.Type() == ARRAY_OBJ && index.Type() == NUMBER_OBJ:
-		return i.evalArrayIndexExpression(left, index)
+	case left.Type() == ARRAY_OBJ && isNumeric(index):
+		return evalArrayIndexExpression(left, index)
+	case left.Type() == MAP_OBJ:
+		return evalMapIndexExpression(left, index)
+	case left.Type() == STRING_OBJ && isNumeric(index):
+		return evalStringIndexExpression(left, index)
+	default:
+		return newError("index operator not supported: %s", left.Type())
+	}
+}
+
+// evalStringIndexExpression returns the character at index as a string.
+// Strings are indexed by character, not byte.
+func evalStringIndexExpression(str, index Object) Object {
+	chars := []rune(str.(*String).Value)
+	idx := toIndex(index)
+
+	if idx < 0 || idx >= len(chars) {
+		return NULL
+	}
+
+	return &String{Value: string(chars[idx])}
+}
+
+// evalSliceExpression returns the characters of a string, or the elements
+// of an array, from start up to but not including end. NULL bounds stand
+// for the start and end of left; others are clamped to them.
+func evalSliceExpression(left, start, end Object) Object {
+	var length int
+	switch left := left.(type) {
+	case *String:
+		length = utf8.RuneCountInString(left.Value)
+	case *Array:
+		length = len(left.Elements)
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+
+	bound := func(obj Object, omitted int) (int, *Error) {
+		if obj == NULL {
+			return omitted, nil
+		}
+		if !isNumeric(obj) {
+			return 0, newError("slice bounds must be INTEGER or NUMBER, got %s", obj.Type())
+		}
+		idx := toIndex(obj)
+		if idx < 0 {
+			return 0, nil
+		}
+		if idx > length {
+			return length, nil
+		}
+		return idx, nil
+	}
+
+	from, err := bound(start, 0)
+	if err != nil {
+		return err
+	}
+	to, err := bound(end, length)
+	if err != nil {
+		return err
+	}
+	if to < from {
+		to = from
+	}
+
+	if str, ok := left.(*String); ok {
+		return &String{Value: string([]rune(str.Value)[from:to])}
+	}
+	elements := make([]Object, to-from)
+	copy(elements, left.(*Array).Elements[from:to])
+	return &Array{Elements: elements}
+}
+
+// newIterator steps through the elements of an array, the characters of a
+// string or the entries of a map, each with its index or key. Arrays are
+// read as the loop goes, so elements assigned ahead of it are seen; a map's
+// entries are those it had when the loop began. Looping over a map without
+// keys yields the keys as the values.
+func newIterator(obj Object, withKeys bool) Object {
+	idx := 0
+
+	switch obj := obj.(type) {
+	case *Array:
+		return &Iterator{Next: func() (Object, Object, bool) {
+			if idx >= len(obj.Elements) {
+				return nil, nil, false
+			}
+			idx++
+			return newInteger(int64(idx - 1)), obj.Elements[idx-1], true
+		}}
+
+	case *String:
+		chars := []rune(obj.Value)
+		return &Iterator{Next: func() (Object, Object, bool) {
+			if idx >= len(chars) {
+				return nil, nil, false
+			}
+			idx++
+			return newInteger(int64(idx - 1)), &String{Value: string(chars[idx-1])}, true
+		}}
+
+	case *Map:
+		entries := obj.Entries()
+		return &Iterator{Next: func() (Object, Object, bool) {
+			if idx >= len(entries) {
+				return nil, nil, false
+			}
+			entry := entries[idx]
+			idx++
+			if !withKeys {
+				return entry.Key, entry.Key, true
+			}
+			return entry.Key, entry.Value, true
+		}}
+
 	default:
-		return newError("index operator not supported: %T", left)
+		return newError("cannot loop over %s", obj.Type())
 	}
 }
 
-func (i *Interpreter) evalArrayIndexExpression(array, index Object) Object {
+// newRangeIterator steps through the integers from start up to and
+// including end, each with its position in the range.
+func newRangeIterator(start, end Object) Object {
+	from, ok := start.(*Integer)
+	if !ok {
+		return newError("range bounds must be INTEGER, got %s", start.Type())
+	}
+	to, ok := end.(*Integer)
+	if !ok {
+		return newError("range bounds must be INTEGER, got %s", end.Type())
+	}
+
+	next, idx := from.Value, int64(0)
+	done := next > to.Value
+	return &Iterator{Next: func() (Object, Object, bool) {
+		if done {
+			return nil, nil, false
+		}
+		value := newInteger(next)
+		done = next == to.Value // stop before next could overflow
+		next++
+		idx++
+		return newInteger(idx - 1), value, true
+	}}
+}
+
+// toIndex returns a number as an index, truncating floats toward zero.
+// Values past any possible length are clamped, so they stay out of range.
+func toIndex(obj Object) int {
+	var f float64
+	if i, ok := obj.(*Integer); ok {
+		f = float64(i.Value)
+	} else {
+		f = math.Trunc(obj.(*Number).Value)
+	}
+	switch {
+	case f > math.MaxInt32:
+		return math.MaxInt32
+	case f < 0 || f != f:
+		return -1
+	}
+	return int(f)
+}
+
+func evalArrayIndexExpression(array, index Object) Object {
 	arrayObject := array.(*Array)
-	idx := int(index.(*Number).Value)
+	idx := toIndex(index)
 	max := len(arrayObject.Elements) - 1
 
 	if idx < 0 || idx > max {
@@ -480,11 +1211,124 @@ func (i *Interpreter) evalArrayIndexExpression(array, index Object) Object {
 	return arrayObject.Elements[idx]
 }
 
+func evalMapIndexExpression(m, index Object) Object {
+	key, ok := index.(Hashable)
+	if !ok {
+		return newError("unusable as map key: %s", index.Type())
+	}
+
+	val, ok := m.(*Map).Get(key)
+	if !ok {
+		return NULL
+	}
+
+	return val
+}
+
+// evalIndexAssignment stores val in a map or array element. Both are
+// changed in place, so every name bound to them sees the new value.
+func evalIndexAssignment(left, index, val Object) Object {
+	if arr, ok := left.(*Array); ok {
+		if !isNumeric(index) {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		idx := toIndex(index)
+		if idx < 0 || idx >= len(arr.Elements) {
+			return newError("index %s out of range for array of length %d", index.Inspect(), len(arr.Elements))
+		}
+		arr.Elements[idx] = val
+		return val
+	}
+
+	m, ok := left.(*Map)
+	if !ok {
+		return newError("index assignment not supported: %s", left.Type())
+	}
+
+	key, ok := index.(Hashable)
+	if !ok {
+		return newError("unusable as map key: %s", index.Type())
+	}
+
+	m.Set(key, val)
+	return val
+}
+
+func (i *Interpreter) evalMapLiteral(node *MapLiteral, env *Environment) Object {
+	m := NewMap()
+
+	for _, pair := range node.Pairs {
+		key := i.Eval(pair.Key, env)
+		if isError(key) {
+			return key
+		}
+
+		hashKey, ok := key.(Hashable)
+		if !ok {
+			return newError("unusable as map key: %s", key.Type())
+		}
+
+		val := i.Eval(pair.Value, env)
+		if isError(val) {
+			return val
+		}
+
+		m.Set(hashKey, val)
+	}
+
+	return m
+}
+
+func evalMemberExpression(object Object, field string) Object {
+	switch object := object.(type) {
+	case *Struct:
+		val, ok := object.Fields[field]
+		if !ok {
+			return newError("garage %s has no field %s", object.Garage.Name, field)
+		}
+		return val
+	case *Module:
+		val, ok := object.Member(field)
+		if !ok {
+			return newError("module %s has no member %s", object.Name, field)
+		}
+		return val
+	default:
+		return newError("field access not supported: %s", object.Type())
+	}
+}
+
+func evalFieldAssignment(object Object, field string, val Object) Object {
+	instance, ok := object.(*Struct)
+	if !ok {
+		return newError("field assignment not supported: %s", object.Type())
+	}
+
+	if !instance.Garage.HasField(field) {
+		return newError("garage %s has no field %s", instance.Garage.Name, field)
+	}
+
+	instance.Fields[field] = val
+	return val
+}
+
+// assign stores val in the existing binding of name, in whichever scope
+// declared it.
+func assign(env *Environment, name string, val Object) Object {
+	if !env.Assign(name, val) {
+		return newError("assignment to undeclared variable %s", name)
+	}
+	return val
+}
+
 func (i *Interpreter) evalIdentifier(node *Identifier, env *Environment) Object {
 	val, ok := env.Get(node.Value)
 	if !ok {
 		return newError("identifier not found: " + node.Value)
 	}
+	if err := i.checkBuiltin(node.Value, val); err != nil {
+		return err
+	}
 
 	return val
 }
@@ -503,19 +1347,85 @@ func (i *Interpreter) evalExpressions(exps []Expression, env *Environment) []Obj
 	return result
 }
 
+// callFunction applies fn for a call made at site. Calls made from Go have
+// no site and are left out of tracebacks.
+func (i *Interpreter) callFunction(fn Object, args []Object, site Position) Object {
+	switch fn := fn.(type) {
+	case *Function:
+		return i.callPace(fn, args, site)
+	case *Builtin:
+		// Functions a builtin calls back into are reported as called from
+		// the builtin's call site.
+		return i.meter.charge(fn.Call(&Host{Streams: &i.Streams, Call: func(callback Object, args ...Object) Object {
+			return i.callFunction(callback, args, site)
+		}, meter: i.meter}, args))
+	default:
+		return i.meter.charge(i.applyFunction(fn, args))
+	}
+}
+
+// callPace records the call on the call stack so that errors raised inside
+// fn carry a traceback.
+func (i *Interpreter) callPace(fn *Function, args []Object, site Position) Object {
+	if err := i.meter.enter(len(i.callStack)); err != nil {
+		return err
+	}
+
+	caller := "<main>"
+	if len(i.callStack) > 0 {
+		caller = i.callStack[len(i.callStack)-1].name
+	}
+
+	i.callStack = append(i.callStack, callFrame{
+		name: fn.Name,
+		site: TraceFrame{Function: caller, File: i.file, Pos: site},
+	})
+	defer func() { i.callStack = i.callStack[:len(i.callStack)-1] }()
+
+	result := i.applyFunction(fn, args)
+	if err, ok := result.(*Error); ok && err.Trace == nil {
+		// The innermost call sees the error first, while the whole stack
+		// is still in place.
+		for _, frame := range i.callStack {
+			err.Trace = append(err.Trace, frame.site)
+		}
+	}
+
+	return result
+}
+
 func (i *Interpreter) applyFunction(fn Object, args []Object) Object {
 	switch fn := fn.(type) {
 	case *Function:
+		// Errors and imports inside the body belong to the defining file.
+		previous := i.file
+		i.file = fn.File
+		defer func() { i.file = previous }()
+
 		extendedEnv := i.extendFunctionEnv(fn, args)
 		evaluated := i.Eval(fn.Body, extendedEnv)
 		return i.unwrapReturnValue(evaluated)
-	case *Builtin:
-		return fn.Fn(args...)
+	case *Garage:
+		return newStruct(fn, args)
 	default:
-		return newError("not a function: %T", fn)
+		return newError("not a function: %s", fn.Type())
 	}
 }
 
+func newStruct(garage *Garage, args []Object) Object {
+	if len(args) != len(garage.Fields) {
+		return newError("wrong number of arguments for garage %s. got=%d, want=%d",
+			garage.Name, len(args), len(garage.Fields))
+	}
+
+	fields := make(map[string]Object, len(garage.Fields))
+	for idx, name := range garage.Fields {
+		fields[name] = args[idx]
+	}
+
+	return &Struct{Garage: garage, Fields: fields}
+}
+
 func (i *Interpreter) extendFunctionEnv(fn *Function, args []Object) *Environment {
 	env := NewEnclosedEnvironment(fn.Env)
 
@@ -532,6 +1442,9 @@ func (i *Interpreter) unwrapReturnValue(obj Object) Object {
 	if returnValue, ok := obj.(*ReturnValue); ok {
 		return returnValue.Value
 	}
+	if obj == nil {
+		return NULL // empty body
+	}
 	return obj
 }
 
@@ -1,8 +1,12 @@
-package main
+package alonso
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType int
@@ -11,6 +15,7 @@ const (
 	// Literals
 	NUMBER TokenType = iota
 	STRING
+	TEMPLATE // a string containing ${...}; Value is its source
 	IDENTIFIER
 	BOOLEAN
 
@@ -19,16 +24,27 @@ const (
 	PACE          // func (racing pace/function)
 	CIRCUIT       // if (racing circuit/conditional)
 	ELSE_CIRCUIT  // else
+	STRATEGY      // switch (race strategy)
+	CASE          // case
+	DEFAULT       // default
+	MATCH         // match (pattern matching)
 	LOOP          // for (racing loop)
+	IN            // in (loop over a formation)
 	WHILE_RACING  // while
 	RETURN_PIT    // return (return to pit)
 	BREAK_FLAG    // break (yellow flag)
 	CONTINUE_RACE // continue
+	SAFETY_CAR    // try (neutralises the race)
+	RECOVER       // catch
 
 	// Data structures
 	FORMATION // array (formation lap)
 	GARAGE    // struct/object
 
+	// Modules
+	IMPORT // import
+	AS     // as
+
 	// Operators
 	ASSIGN   // =
 	PLUS     // +
@@ -37,6 +53,14 @@ const (
 	DIVIDE   // /
 	MODULO   // %
 
+	PLUS_ASSIGN     // +=
+	MINUS_ASSIGN    // -=
+	MULTIPLY_ASSIGN // *=
+	DIVIDE_ASSIGN   // /=
+	MODULO_ASSIGN   // %=
+	INCREMENT       // ++
+	DECREMENT       // --
+
 	// Comparison
 	EQUAL         // ==
 	NOT_EQUAL     // !=
@@ -54,6 +78,10 @@ const (
 	SEMICOLON // ;
 	COMMA     // ,
 	DOT       // .
+	RANGE     // ..
+	ELLIPSIS  // ...
+	COLON     // :
+	ARROW     // =>
 
 	// Brackets
 	LPAREN   // (
@@ -76,6 +104,16 @@ type Token struct {
 	Column int
 }
 
+// Position is a 1-based line and column in the source.
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (t Token) Pos() Position {
+	return Position{Line: t.Line, Column: t.Column}
+}
+
 type Lexer struct {
 	input    string
 	position int
@@ -109,26 +147,48 @@ func (l *Lexer) NextToken() Token {
 	switch ch {
 	case '\n':
 		token := Token{Type: NEWLINE, Value: string(ch), Line: l.line, Column: l.column}
-		l.advance()
-		l.line++
-		l.column = 1
+		l.newline()
 		return token
 	case '=':
-		if l.peek() == '=' {
+		switch l.peek() {
+		case '=':
 			l.advance()
 			l.advance()
 			return Token{Type: EQUAL, Value: "==", Line: l.line, Column: l.column - 2}
+		case '>':
+			return l.doubleCharToken(ARROW)
 		}
 		return l.singleCharToken(ASSIGN)
 	case '+':
+		switch l.peek() {
+		case '=':
+			return l.doubleCharToken(PLUS_ASSIGN)
+		case '+':
+			return l.doubleCharToken(INCREMENT)
+		}
 		return l.singleCharToken(PLUS)
 	case '-':
+		switch l.peek() {
+		case '=':
+			return l.doubleCharToken(MINUS_ASSIGN)
+		case '-':
+			return l.doubleCharToken(DECREMENT)
+		}
 		return l.singleCharToken(MINUS)
 	case '*':
+		if l.peek() == '=' {
+			return l.doubleCharToken(MULTIPLY_ASSIGN)
+		}
 		return l.singleCharToken(MULTIPLY)
 	case '/':
+		if l.peek() == '=' {
+			return l.doubleCharToken(DIVIDE_ASSIGN)
+		}
 		return l.singleCharToken(DIVIDE)
 	case '%':
+		if l.peek() == '=' {
+			return l.doubleCharToken(MODULO_ASSIGN)
+		}
 		return l.singleCharToken(MODULO)
 	case '!':
 		if l.peek() == '=' {
@@ -157,20 +217,33 @@ func (l *Lexer) NextToken() Token {
 			l.advance()
 			return Token{Type: AND, Value: "&&", Line: l.line, Column: l.column - 2}
 		}
-		return Token{Type: ILLEGAL, Value: string(ch), Line: l.line, Column: l.column}
+		return l.singleCharToken(ILLEGAL)
 	case '|':
 		if l.peek() == '|' {
 			l.advance()
 			l.advance()
 			return Token{Type: OR, Value: "||", Line: l.line, Column: l.column - 2}
 		}
-		return Token{Type: ILLEGAL, Value: string(ch), Line: l.line, Column: l.column}
+		return l.singleCharToken(ILLEGAL)
 	case ';':
 		return l.singleCharToken(SEMICOLON)
 	case ',':
 		return l.singleCharToken(COMMA)
 	case '.':
+		if strings.HasPrefix(l.input[l.position:], "...") {
+			l.advance()
+			l.advance()
+			l.advance()
+			return Token{Type: ELLIPSIS, Value: "...", Line: l.line, Column: l.column - 3}
+		}
+		if l.peek() == '.' {
+			l.advance()
+			l.advance()
+			return Token{Type: RANGE, Value: "..", Line: l.line, Column: l.column - 2}
+		}
 		return l.singleCharToken(DOT)
+	case ':':
+		return l.singleCharToken(COLON)
 	case '(':
 		return l.singleCharToken(LPAREN)
 	case ')':
@@ -185,29 +258,60 @@ func (l *Lexer) NextToken() Token {
 		return l.singleCharToken(RBRACKET)
 	case '"':
 		return l.readString()
+	case '`':
+		return l.readRawString()
 	default:
-		if unicode.IsDigit(rune(ch)) {
+		if isDigit(ch) {
 			return l.readNumber()
 		}
-		if unicode.IsLetter(rune(ch)) || ch == '_' {
+		if isIdentifierStart(l.current()) {
 			return l.readIdentifier()
 		}
-		return Token{Type: ILLEGAL, Value: string(ch), Line: l.line, Column: l.column}
+		return l.singleCharToken(ILLEGAL)
 	}
 }
 
+// singleCharToken makes a token of the character at the current position,
+// which may take several bytes.
 func (l *Lexer) singleCharToken(tokenType TokenType) Token {
-	ch := l.input[l.position]
-	token := Token{Type: tokenType, Value: string(ch), Line: l.line, Column: l.column}
+	start := l.position
+	token := Token{Type: tokenType, Line: l.line, Column: l.column}
+	l.advance()
+	token.Value = l.input[start:l.position]
+	return token
+}
+
+// doubleCharToken makes a token of the two ASCII characters at the current
+// position.
+func (l *Lexer) doubleCharToken(tokenType TokenType) Token {
+	token := Token{Type: tokenType, Value: l.input[l.position : l.position+2], Line: l.line, Column: l.column}
+	l.advance()
 	l.advance()
 	return token
 }
 
+// current decodes the character at the current position. Bytes that are not
+// valid UTF-8 decode as utf8.RuneError, one at a time.
+func (l *Lexer) current() rune {
+	r, _ := utf8.DecodeRuneInString(l.input[l.position:])
+	return r
+}
+
+// advance moves past the current character. Columns count characters, not
+// bytes.
 func (l *Lexer) advance() {
-	l.position++
+	_, size := utf8.DecodeRuneInString(l.input[l.position:])
+	l.position += size
 	l.column++
 }
 
+// newline steps over a '\n' onto the start of the next line.
+func (l *Lexer) newline() {
+	l.advance()
+	l.line++
+	l.column = 1
+}
+
 func (l *Lexer) peek() byte {
 	if l.position+1 >= len(l.input) {
 		return 0
@@ -232,42 +336,296 @@ func (l *Lexer) skipComment() {
 	}
 }
 
+const unterminatedString = "unterminated string"
+
+// readString reads a double-quoted string and decodes its escape sequences.
+// A string containing ${...} becomes a TEMPLATE token holding the source
+// between the quotes, which the parser splits into text and expressions.
 func (l *Lexer) readString() Token {
 	start := l.position
-	startCol := l.column
+	line, column := l.line, l.column
+
+	parts, msg := l.scanString()
+	if msg != "" {
+		return Token{Type: ILLEGAL, Value: msg, Line: line, Column: column}
+	}
+
+	if len(parts) == 1 && !parts[0].expr {
+		return Token{Type: STRING, Value: parts[0].text, Line: line, Column: column}
+	}
+	return Token{Type: TEMPLATE, Value: l.input[start+1 : l.position-1], Line: line, Column: column}
+}
+
+// stringPart is a piece of a double-quoted string: decoded text, or the
+// source of an interpolated expression and where that source starts.
+type stringPart struct {
+	text string
+	expr bool
+	pos  Position
+}
+
+// scanString reads the double-quoted string at the current position. It
+// returns the first problem found, but reads on to the closing quote so that
+// lexing resumes after the string. A string still open at the end of the
+// line is unterminated, and lexing resumes at the newline.
+func (l *Lexer) scanString() ([]stringPart, string) {
 	l.advance() // skip opening quote
 
-	for l.position < len(l.input) && l.input[l.position] != '"' {
-		l.advance()
+	parts := []stringPart{}
+	text := strings.Builder{}
+	msg := ""
+
+	for {
+		if l.position >= len(l.input) {
+			return nil, unterminatedString
+		}
+
+		switch ch := l.input[l.position]; {
+		case ch == '"':
+			l.advance()
+			if text.Len() > 0 || len(parts) == 0 {
+				parts = append(parts, stringPart{text: text.String()})
+			}
+			return parts, msg
+
+		case ch == '\\':
+			decoded, problem := l.readEscape()
+			if problem != "" && msg == "" {
+				msg = problem
+			}
+			text.WriteString(decoded)
+
+		case ch == '$' && l.peek() == '{':
+			if text.Len() > 0 {
+				parts = append(parts, stringPart{text: text.String()})
+				text.Reset()
+			}
+			l.advance()
+			l.advance()
+
+			start, pos := l.position, Position{Line: l.line, Column: l.column}
+			if !l.skipInterpolation() {
+				return nil, unterminatedString
+			}
+			parts = append(parts, stringPart{text: l.input[start:l.position], expr: true, pos: pos})
+			l.advance() // skip closing brace
+
+		case ch == '\n':
+			// Backtick strings are the ones that span lines.
+			return nil, unterminatedString
+
+		default:
+			start := l.position
+			l.advance()
+			text.WriteString(l.input[start:l.position])
+		}
 	}
+}
 
+// readEscape decodes the escape sequence at the current position.
+func (l *Lexer) readEscape() (string, string) {
+	l.advance() // skip backslash
 	if l.position >= len(l.input) {
-		return Token{Type: ILLEGAL, Value: "unterminated string", Line: l.line, Column: startCol}
+		return "", unterminatedString
 	}
 
-	value := l.input[start+1 : l.position]
-	l.advance() // skip closing quote
+	ch := l.current()
+	if ch == '\n' {
+		return "", unterminatedString
+	}
+	l.advance()
 
-	return Token{Type: STRING, Value: value, Line: l.line, Column: startCol}
+	switch ch {
+	case 'n':
+		return "\n", ""
+	case 't':
+		return "\t", ""
+	case 'r':
+		return "\r", ""
+	case '"':
+		return "\"", ""
+	case '\\':
+		return "\\", ""
+	case '$':
+		return "$", ""
+	case 'u':
+		if l.position >= len(l.input) || l.input[l.position] != '{' {
+			return "", "invalid unicode escape: expected \\u{...}"
+		}
+		l.advance()
+
+		start := l.position
+		for l.position < len(l.input) && l.input[l.position] != '}' && l.input[l.position] != '"' {
+			l.advance()
+		}
+		digits := l.input[start:l.position]
+		if l.position >= len(l.input) || l.input[l.position] != '}' {
+			return "", "invalid unicode escape: expected \\u{...}"
+		}
+		l.advance()
+
+		code, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(code)) {
+			return "", fmt.Sprintf("invalid unicode escape \\u{%s}", digits)
+		}
+		return string(rune(code)), ""
+	}
+
+	return "", fmt.Sprintf("invalid escape sequence \\%c", ch)
+}
+
+// skipInterpolation moves to the brace closing the ${ just read, stepping
+// over nested braces and strings. It reports false if the line ends first.
+func (l *Lexer) skipInterpolation() bool {
+	depth := 0
+
+	for l.position < len(l.input) {
+		switch l.input[l.position] {
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return true
+			}
+			depth--
+		case '"':
+			if _, msg := l.scanString(); msg == unterminatedString {
+				return false
+			}
+			continue
+		case '`':
+			if !l.scanRawString() {
+				return false
+			}
+			continue
+		case '\n':
+			return false
+		}
+		l.advance()
+	}
+
+	return false
+}
+
+// readRawString reads a backtick string. It has no escapes or
+// interpolation and may span lines; carriage returns are dropped so a file
+// reads the same with either line ending.
+func (l *Lexer) readRawString() Token {
+	start := l.position
+	line, column := l.line, l.column
+
+	if !l.scanRawString() {
+		return Token{Type: ILLEGAL, Value: unterminatedString, Line: line, Column: column}
+	}
+
+	value := strings.ReplaceAll(l.input[start+1:l.position-1], "\r", "")
+	return Token{Type: STRING, Value: value, Line: line, Column: column}
 }
 
+func (l *Lexer) scanRawString() bool {
+	l.advance() // skip opening backtick
+
+	for l.position < len(l.input) {
+		switch l.input[l.position] {
+		case '`':
+			l.advance()
+			return true
+		case '\n':
+			l.newline()
+		default:
+			l.advance()
+		}
+	}
+
+	return false
+}
+
+// readNumber reads a number literal: decimal digits with an optional
+// fraction and exponent, or an integer in hex (0x), binary (0b) or octal
+// (0o). Underscores may separate digits. Letters, digits and decimal points
+// running on from a literal are read as part of it, so that 1.2.3 or 12ab
+// is reported as one illegal literal.
 func (l *Lexer) readNumber() Token {
 	start := l.position
 	startCol := l.column
 
-	for l.position < len(l.input) && (unicode.IsDigit(rune(l.input[l.position])) || l.input[l.position] == '.') {
+	for l.position < len(l.input) && l.continuesNumber(l.input[start:l.position]) {
 		l.advance()
 	}
 
 	value := l.input[start:l.position]
+	if msg := checkNumber(value); msg != "" {
+		return Token{Type: ILLEGAL, Value: msg, Line: l.line, Column: startCol}
+	}
 	return Token{Type: NUMBER, Value: value, Line: l.line, Column: startCol}
 }
 
+// continuesNumber reports whether the current character belongs to the
+// number literal text. A decimal point must be followed by a digit, so that
+// 1..5 is a range, and a sign only belongs to a decimal exponent.
+func (l *Lexer) continuesNumber(text string) bool {
+	ch := l.input[l.position]
+	switch {
+	case isDigit(ch), isASCIILetter(ch), ch == '_':
+		return true
+	case ch == '.':
+		return isDigit(l.peek())
+	case ch == '+', ch == '-':
+		last := text[len(text)-1]
+		return (last == 'e' || last == 'E') && numberBase(text) == 10 && isDigit(l.peek())
+	}
+	return false
+}
+
+var (
+	decimalLiteral = regexp.MustCompile(`^[0-9](_?[0-9])*(\.[0-9](_?[0-9])*)?([eE][+-]?[0-9](_?[0-9])*)?$`)
+	prefixedDigits = map[int]string{16: "0123456789abcdefABCDEF", 8: "01234567", 2: "01"}
+	baseNames      = map[int]string{16: "hex", 8: "octal", 2: "binary"}
+)
+
+// numberBase returns the base a number literal is written in.
+func numberBase(text string) int {
+	if len(text) < 2 || text[0] != '0' {
+		return 10
+	}
+	switch text[1] {
+	case 'x', 'X':
+		return 16
+	case 'o', 'O':
+		return 8
+	case 'b', 'B':
+		return 2
+	}
+	return 10
+}
+
+// checkNumber returns why text is not a valid number literal, or "".
+func checkNumber(text string) string {
+	base := numberBase(text)
+	if base == 10 {
+		if !decimalLiteral.MatchString(text) {
+			return fmt.Sprintf("invalid number literal %s", text)
+		}
+		return ""
+	}
+
+	digits := text[2:]
+	for _, ch := range digits {
+		if ch != '_' && !strings.ContainsRune(prefixedDigits[base], ch) {
+			return fmt.Sprintf("invalid digit %q in %s literal %s", ch, baseNames[base], text)
+		}
+	}
+	if digits == "" || strings.Contains(digits, "__") || strings.HasSuffix(digits, "_") {
+		return fmt.Sprintf("invalid number literal %s", text)
+	}
+	return ""
+}
+
 func (l *Lexer) readIdentifier() Token {
 	start := l.position
 	startCol := l.column
 
-	for l.position < len(l.input) && (unicode.IsLetter(rune(l.input[l.position])) || unicode.IsDigit(rune(l.input[l.position])) || l.input[l.position] == '_') {
+	for l.position < len(l.input) && isIdentifierPart(l.current()) {
 		l.advance()
 	}
 
@@ -277,19 +635,52 @@ func (l *Lexer) readIdentifier() Token {
 	return Token{Type: tokenType, Value: value, Line: l.line, Column: startCol}
 }
 
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
+func isASCIILetter(ch byte) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z'
+}
+
+// Identifiers start with a letter or underscore in any script and continue
+// with letters, digits and underscores, as in Go.
+func isIdentifierStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_'
+}
+
+func isIdentifierPart(r rune) bool {
+	return isIdentifierStart(r) || unicode.IsDigit(r)
+}
+
+// isIdentifier reports whether name lexes as exactly one identifier.
+func isIdentifier(name string) bool {
+	token := NewLexer(name).NextToken()
+	return token.Type == IDENTIFIER && token.Value == name
+}
+
 func (l *Lexer) getKeywordType(identifier string) TokenType {
 	keywords := map[string]TokenType{
 		"grid":          GRID,
 		"pace":          PACE,
 		"circuit":       CIRCUIT,
 		"else_circuit":  ELSE_CIRCUIT,
+		"strategy":      STRATEGY,
+		"case":          CASE,
+		"default":       DEFAULT,
+		"match":         MATCH,
 		"loop":          LOOP,
+		"in":            IN,
 		"while_racing":  WHILE_RACING,
 		"return_pit":    RETURN_PIT,
 		"break_flag":    BREAK_FLAG,
 		"continue_race": CONTINUE_RACE,
+		"safety_car":    SAFETY_CAR,
+		"recover":       RECOVER,
 		"formation":     FORMATION,
 		"garage":        GARAGE,
+		"import":        IMPORT,
+		"as":            AS,
 		"true":          BOOLEAN,
 		"false":         BOOLEAN,
 	}
@@ -303,16 +694,21 @@ func (l *Lexer) getKeywordType(identifier string) TokenType {
 
 func (t TokenType) String() string {
 	names := map[TokenType]string{
-		NUMBER: "NUMBER", STRING: "STRING", IDENTIFIER: "IDENTIFIER", BOOLEAN: "BOOLEAN",
+		NUMBER: "NUMBER", STRING: "STRING", TEMPLATE: "TEMPLATE", IDENTIFIER: "IDENTIFIER", BOOLEAN: "BOOLEAN",
 		GRID: "GRID", PACE: "PACE", CIRCUIT: "CIRCUIT", ELSE_CIRCUIT: "ELSE_CIRCUIT",
-		LOOP: "LOOP", WHILE_RACING: "WHILE_RACING", RETURN_PIT: "RETURN_PIT",
+		STRATEGY: "STRATEGY", CASE: "CASE", DEFAULT: "DEFAULT", MATCH: "MATCH",
+		LOOP: "LOOP", IN: "IN", WHILE_RACING: "WHILE_RACING", RETURN_PIT: "RETURN_PIT",
 		BREAK_FLAG: "BREAK_FLAG", CONTINUE_RACE: "CONTINUE_RACE",
+		SAFETY_CAR: "SAFETY_CAR", RECOVER: "RECOVER",
 		FORMATION: "FORMATION", GARAGE: "GARAGE",
+		IMPORT: "IMPORT", AS: "AS",
 		ASSIGN: "ASSIGN", PLUS: "PLUS", MINUS: "MINUS", MULTIPLY: "MULTIPLY", DIVIDE: "DIVIDE", MODULO: "MODULO",
+		PLUS_ASSIGN: "PLUS_ASSIGN", MINUS_ASSIGN: "MINUS_ASSIGN", MULTIPLY_ASSIGN: "MULTIPLY_ASSIGN",
+		DIVIDE_ASSIGN: "DIVIDE_ASSIGN", MODULO_ASSIGN: "MODULO_ASSIGN", INCREMENT: "INCREMENT", DECREMENT: "DECREMENT",
 		EQUAL: "EQUAL", NOT_EQUAL: "NOT_EQUAL", LESS: "LESS", LESS_EQUAL: "LESS_EQUAL",
 		GREATER: "GREATER", GREATER_EQUAL: "GREATER_EQUAL",
 		AND: "AND", OR: "OR", NOT: "NOT",
-		SEMICOLON: "SEMICOLON", COMMA: "COMMA", DOT: "DOT",
+		SEMICOLON: "SEMICOLON", COMMA: "COMMA", DOT: "DOT", RANGE: "RANGE", ELLIPSIS: "ELLIPSIS", COLON: "COLON", ARROW: "ARROW",
 		LPAREN: "LPAREN", RPAREN: "RPAREN", LBRACE: "LBRACE", RBRACE: "RBRACE",
 		LBRACKET: "LBRACKET", RBRACKET: "RBRACKET",
 		NEWLINE: "NEWLINE", EOF: "EOF", ILLEGAL: "ILLEGAL",
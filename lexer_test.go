@@ -0,0 +1,304 @@
+package alonso
+
+import "testing"
+
+func TestNextToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Token
+	}{
+		{
+			name:  "grid statement",
+			input: "grid x = 5;",
+			want: []Token{
+				{GRID, "grid", 1, 1},
+				{IDENTIFIER, "x", 1, 6},
+				{ASSIGN, "=", 1, 8},
+				{NUMBER, "5", 1, 10},
+				{SEMICOLON, ";", 1, 11},
+				{EOF, "", 1, 12},
+			},
+		},
+		{
+			name:  "two character operators",
+			input: "== != <= >= && ||",
+			want: []Token{
+				{EQUAL, "==", 1, 1},
+				{NOT_EQUAL, "!=", 1, 4},
+				{LESS_EQUAL, "<=", 1, 7},
+				{GREATER_EQUAL, ">=", 1, 10},
+				{AND, "&&", 1, 13},
+				{OR, "||", 1, 16},
+				{EOF, "", 1, 18},
+			},
+		},
+		{
+			name:  "single character operators and delimiters",
+			input: "+-*/%!<>,.:(){}[]",
+			want: []Token{
+				{PLUS, "+", 1, 1},
+				{MINUS, "-", 1, 2},
+				{MULTIPLY, "*", 1, 3},
+				{DIVIDE, "/", 1, 4},
+				{MODULO, "%", 1, 5},
+				{NOT, "!", 1, 6},
+				{LESS, "<", 1, 7},
+				{GREATER, ">", 1, 8},
+				{COMMA, ",", 1, 9},
+				{DOT, ".", 1, 10},
+				{COLON, ":", 1, 11},
+				{LPAREN, "(", 1, 12},
+				{RPAREN, ")", 1, 13},
+				{LBRACE, "{", 1, 14},
+				{RBRACE, "}", 1, 15},
+				{LBRACKET, "[", 1, 16},
+				{RBRACKET, "]", 1, 17},
+				{EOF, "", 1, 18},
+			},
+		},
+		{
+			name:  "keywords",
+			input: "pace circuit else_circuit loop while_racing return_pit break_flag continue_race",
+			want: []Token{
+				{PACE, "pace", 1, 1},
+				{CIRCUIT, "circuit", 1, 6},
+				{ELSE_CIRCUIT, "else_circuit", 1, 14},
+				{LOOP, "loop", 1, 27},
+				{WHILE_RACING, "while_racing", 1, 32},
+				{RETURN_PIT, "return_pit", 1, 45},
+				{BREAK_FLAG, "break_flag", 1, 56},
+				{CONTINUE_RACE, "continue_race", 1, 67},
+				{EOF, "", 1, 80},
+			},
+		},
+		{
+			name:  "more keywords",
+			input: "formation garage import as safety_car recover true false",
+			want: []Token{
+				{FORMATION, "formation", 1, 1},
+				{GARAGE, "garage", 1, 11},
+				{IMPORT, "import", 1, 18},
+				{AS, "as", 1, 25},
+				{SAFETY_CAR, "safety_car", 1, 28},
+				{RECOVER, "recover", 1, 39},
+				{BOOLEAN, "true", 1, 47},
+				{BOOLEAN, "false", 1, 52},
+				{EOF, "", 1, 57},
+			},
+		},
+		{
+			name:  "strategy keywords",
+			input: "strategy case default",
+			want: []Token{
+				{STRATEGY, "strategy", 1, 1},
+				{CASE, "case", 1, 10},
+				{DEFAULT, "default", 1, 15},
+				{EOF, "", 1, 22},
+			},
+		},
+		{
+			name:  "patterns",
+			input: "match [a, ...b] => 1..2",
+			want: []Token{
+				{MATCH, "match", 1, 1},
+				{LBRACKET, "[", 1, 7},
+				{IDENTIFIER, "a", 1, 8},
+				{COMMA, ",", 1, 9},
+				{ELLIPSIS, "...", 1, 11},
+				{IDENTIFIER, "b", 1, 14},
+				{RBRACKET, "]", 1, 15},
+				{ARROW, "=>", 1, 17},
+				{NUMBER, "1", 1, 20},
+				{RANGE, "..", 1, 21},
+				{NUMBER, "2", 1, 23},
+				{EOF, "", 1, 24},
+			},
+		},
+		{
+			name:  "ranges",
+			input: "loop (lap in 1..58)",
+			want: []Token{
+				{LOOP, "loop", 1, 1},
+				{LPAREN, "(", 1, 6},
+				{IDENTIFIER, "lap", 1, 7},
+				{IN, "in", 1, 11},
+				{NUMBER, "1", 1, 14},
+				{RANGE, "..", 1, 15},
+				{NUMBER, "58", 1, 17},
+				{RPAREN, ")", 1, 19},
+				{EOF, "", 1, 20},
+			},
+		},
+		{
+			name:  "identifiers and numbers",
+			input: "lap_2 _pit 3.14 42",
+			want: []Token{
+				{IDENTIFIER, "lap_2", 1, 1},
+				{IDENTIFIER, "_pit", 1, 7},
+				{NUMBER, "3.14", 1, 12},
+				{NUMBER, "42", 1, 17},
+				{EOF, "", 1, 19},
+			},
+		},
+		{
+			name:  "strings",
+			input: `"Fernando Alonso" ""`,
+			want: []Token{
+				{STRING, "Fernando Alonso", 1, 1},
+				{STRING, "", 1, 19},
+				{EOF, "", 1, 21},
+			},
+		},
+		{
+			name:  "escape sequences",
+			input: `"say \"box\"\tnow\\\n" "\u{1F3CE}\u{e9}" "\$"`,
+			want: []Token{
+				{STRING, "say \"box\"\tnow\\\n", 1, 1},
+				{STRING, "\U0001F3CE\u00e9", 1, 24},
+				{STRING, "$", 1, 42},
+				{EOF, "", 1, 46},
+			},
+		},
+		{
+			name:  "raw strings span lines",
+			input: "`C:\\pit\n${lap}\r\n\\n` x",
+			want: []Token{
+				{STRING, "C:\\pit\n${lap}\n\\n", 1, 1},
+				{IDENTIFIER, "x", 3, 5},
+				{EOF, "", 3, 6},
+			},
+		},
+		{
+			name:  "interpolated strings",
+			input: "\"lap ${n} of ${m[\"}\"]}\"\nx",
+			want: []Token{
+				{TEMPLATE, "lap ${n} of ${m[\"}\"]}", 1, 1},
+				{NEWLINE, "\n", 1, 24},
+				{IDENTIFIER, "x", 2, 1},
+			},
+		},
+		{
+			name:  "bad escapes consume the whole string",
+			input: `"a\qb" x`,
+			want: []Token{
+				{ILLEGAL, `invalid escape sequence \q`, 1, 1},
+				{IDENTIFIER, "x", 1, 8},
+			},
+		},
+		{
+			name:  "newlines and comments",
+			input: "a // lap one\n\tb\n",
+			want: []Token{
+				{IDENTIFIER, "a", 1, 1},
+				{NEWLINE, "\n", 1, 13},
+				{IDENTIFIER, "b", 2, 2},
+				{NEWLINE, "\n", 2, 3},
+				{EOF, "", 3, 1},
+			},
+		},
+		{
+			name:  "unterminated string",
+			input: `x = "box box`,
+			want: []Token{
+				{IDENTIFIER, "x", 1, 1},
+				{ASSIGN, "=", 1, 3},
+				{ILLEGAL, "unterminated string", 1, 5},
+			},
+		},
+		{
+			name:  "double-quoted strings end at the line",
+			input: "\"box\nx \"${lap\ny",
+			want: []Token{
+				{ILLEGAL, "unterminated string", 1, 1},
+				{NEWLINE, "\n", 1, 5},
+				{IDENTIFIER, "x", 2, 1},
+				{ILLEGAL, "unterminated string", 2, 3},
+				{NEWLINE, "\n", 2, 9},
+				{IDENTIFIER, "y", 3, 1},
+			},
+		},
+		{
+			name:  "compound assignment",
+			input: "a += 1 -= *= /= %= ++ -- - -",
+			want: []Token{
+				{IDENTIFIER, "a", 1, 1},
+				{PLUS_ASSIGN, "+=", 1, 3},
+				{NUMBER, "1", 1, 6},
+				{MINUS_ASSIGN, "-=", 1, 8},
+				{MULTIPLY_ASSIGN, "*=", 1, 11},
+				{DIVIDE_ASSIGN, "/=", 1, 14},
+				{MODULO_ASSIGN, "%=", 1, 17},
+				{INCREMENT, "++", 1, 20},
+				{DECREMENT, "--", 1, 23},
+				{MINUS, "-", 1, 26},
+				{MINUS, "-", 1, 28},
+			},
+		},
+		{
+			name:  "number literals",
+			input: "42 1_000_000 0xFF 0b1010 0o17 2.5e-3 1E6 1..5",
+			want: []Token{
+				{NUMBER, "42", 1, 1},
+				{NUMBER, "1_000_000", 1, 4},
+				{NUMBER, "0xFF", 1, 14},
+				{NUMBER, "0b1010", 1, 19},
+				{NUMBER, "0o17", 1, 26},
+				{NUMBER, "2.5e-3", 1, 31},
+				{NUMBER, "1E6", 1, 38},
+				{NUMBER, "1", 1, 42},
+				{RANGE, "..", 1, 43},
+				{NUMBER, "5", 1, 45},
+			},
+		},
+		{
+			name:  "malformed numbers are read whole",
+			input: "1.2.3 12ab 1__0 0b102 0x 5e x",
+			want: []Token{
+				{ILLEGAL, "invalid number literal 1.2.3", 1, 1},
+				{ILLEGAL, "invalid number literal 12ab", 1, 7},
+				{ILLEGAL, "invalid number literal 1__0", 1, 12},
+				{ILLEGAL, "invalid digit '2' in binary literal 0b102", 1, 17},
+				{ILLEGAL, "invalid number literal 0x", 1, 23},
+				{ILLEGAL, "invalid number literal 5e", 1, 26},
+				{IDENTIFIER, "x", 1, 29},
+			},
+		},
+		{
+			name:  "unicode identifiers count columns in characters",
+			input: "grid Räikkönen = \"Pérez\" ¬ x",
+			want: []Token{
+				{GRID, "grid", 1, 1},
+				{IDENTIFIER, "Räikkönen", 1, 6},
+				{ASSIGN, "=", 1, 16},
+				{STRING, "Pérez", 1, 18},
+				{ILLEGAL, "¬", 1, 26},
+				{IDENTIFIER, "x", 1, 28},
+			},
+		},
+		{
+			name:  "illegal characters",
+			input: "@ & | #",
+			want: []Token{
+				{ILLEGAL, "@", 1, 1},
+				{ILLEGAL, "&", 1, 3},
+				{ILLEGAL, "|", 1, 5},
+				{ILLEGAL, "#", 1, 7},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLexer(tt.input)
+			for i, want := range tt.want {
+				got := l.NextToken()
+				if got != want {
+					t.Fatalf("token %d: got %s %q at %d:%d, want %s %q at %d:%d", i,
+						got.Type, got.Value, got.Line, got.Column,
+						want.Type, want.Value, want.Line, want.Column)
+				}
+			}
+		})
+	}
+}
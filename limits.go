@@ -0,0 +1,154 @@
+package alonso
+
+import (
+	"context"
+	"errors"
+)
+
+// DefaultMaxDepth is the call depth allowed when Limits.MaxDepth is zero.
+const DefaultMaxDepth = 1000
+
+// contextInterval is how many steps run between checks of the context.
+const contextInterval = 1024
+
+// The errors a program stops with when it exceeds its Limits. A RuntimeError
+// raised for one unwraps to it, and a cancelled run unwraps to the context's
+// error, so a host can tell them apart with errors.Is.
+var (
+	ErrStepLimit   = errors.New("step limit exceeded")
+	ErrCallDepth   = errors.New("maximum call depth exceeded")
+	ErrMemoryLimit = errors.New("memory limit exceeded")
+	ErrOutputLimit = errors.New("output limit exceeded")
+)
+
+// Limits bound the resources a single Execute or Call may use. Interpreter
+// and Machine embed them; zero fields are unlimited, except MaxDepth, which
+// defaults to DefaultMaxDepth. Exceeding a limit raises a runtime error that
+// safety_car can recover, but the limit stays exceeded, so a program cannot
+// use recover to keep running past it.
+type Limits struct {
+	MaxSteps  int64 // AST nodes evaluated or VM instructions executed
+	MaxDepth  int   // nested pace calls
+	MaxMemory int64 // approximate bytes allocated for strings, arrays, maps and structs
+	MaxOutput int64 // bytes written to Stdout
+}
+
+// meter tracks the resources one run has used.
+type meter struct {
+	ctx     context.Context
+	limits  Limits
+	steps   int64
+	memory  int64
+	written int64
+}
+
+func newMeter(ctx context.Context, limits Limits) *meter {
+	if limits.MaxDepth <= 0 {
+		limits.MaxDepth = DefaultMaxDepth
+	}
+	return &meter{ctx: ctx, limits: limits}
+}
+
+// step counts one step of evaluation.
+func (m *meter) step() *Error {
+	m.steps++
+	if m.limits.MaxSteps > 0 && m.steps > m.limits.MaxSteps {
+		return newLimitError(ErrStepLimit, "%s (%d steps)", ErrStepLimit, m.limits.MaxSteps)
+	}
+	if m.steps%contextInterval == 0 {
+		if err := m.ctx.Err(); err != nil {
+			return newLimitError(err, "execution cancelled: %s", err)
+		}
+	}
+	return nil
+}
+
+// enter checks that a call can be made with depth calls already active.
+func (m *meter) enter(depth int) *Error {
+	if depth >= m.limits.MaxDepth {
+		return newLimitError(ErrCallDepth, "%s (%d calls)", ErrCallDepth, m.limits.MaxDepth)
+	}
+	return nil
+}
+
+// charge accounts for obj, a value just created, and returns it, or an
+// error if the memory limit is now exceeded.
+func (m *meter) charge(obj Object) Object {
+	if err := m.allocate(sizeOf(obj)); err != nil {
+		return err
+	}
+	return obj
+}
+
+// setIndex stores val as evalIndexAssignment does, charging for the entry
+// when it adds a key to a map.
+func (m *meter) setIndex(left, index, val Object) Object {
+	before := sizeOf(left)
+	result := evalIndexAssignment(left, index, val)
+	if isError(result) {
+		return result
+	}
+	if err := m.allocate(sizeOf(left) - before); err != nil {
+		return err
+	}
+	return result
+}
+
+// allocate accounts for size more bytes in use.
+func (m *meter) allocate(size int64) *Error {
+	if m.limits.MaxMemory <= 0 || size <= 0 {
+		return nil
+	}
+
+	m.memory += size
+	if m.memory > m.limits.MaxMemory {
+		return newLimitError(ErrMemoryLimit, "%s (%d bytes)", ErrMemoryLimit, m.limits.MaxMemory)
+	}
+	return nil
+}
+
+// output accounts for n bytes about to be written. If that would pass the
+// output limit, it returns how many of them fit along with an error.
+func (m *meter) output(n int) (int, *Error) {
+	if m.limits.MaxOutput <= 0 {
+		return n, nil
+	}
+
+	fits := m.limits.MaxOutput - m.written
+	if fits < 0 {
+		fits = 0
+	}
+	m.written += int64(n)
+	if int64(n) > fits {
+		return int(fits), newLimitError(ErrOutputLimit, "%s (%d bytes)", ErrOutputLimit, m.limits.MaxOutput)
+	}
+	return n, nil
+}
+
+// Resources exposes the limits through the Backend interface.
+func (l *Limits) Resources() *Limits {
+	return l
+}
+
+// sizeOf estimates the memory obj itself occupies. Elements are counted
+// when they are created, not again by each container holding them.
+func sizeOf(obj Object) int64 {
+	switch obj := obj.(type) {
+	case *String:
+		return 16 + int64(len(obj.Value))
+	case *Array:
+		return 24 + 16*int64(len(obj.Elements))
+	case *Map:
+		return 48 + 64*int64(len(obj.Pairs))
+	case *Struct:
+		return 48 + 64*int64(len(obj.Fields))
+	default:
+		return 0
+	}
+}
+
+func newLimitError(cause error, format string, a ...interface{}) *Error {
+	err := newError(format, a...)
+	err.Cause = cause
+	return err
+}
@@ -0,0 +1,157 @@
+package alonso
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		input   string
+		want    error
+		message string
+	}{
+		{
+			name:    "step limit stops an endless loop",
+			limits:  Limits{MaxSteps: 10000},
+			input:   "while_racing (true) { }",
+			want:    ErrStepLimit,
+			message: "step limit exceeded (10000 steps)",
+		},
+		{
+			name:    "step limit outlasts recover",
+			limits:  Limits{MaxSteps: 10000},
+			input:   `safety_car { while_racing (true) { } } recover (err) { telemetry("escaped") }`,
+			want:    ErrStepLimit,
+			message: "step limit exceeded (10000 steps)",
+		},
+		{
+			name:    "default call depth",
+			input:   "pace down(n) { return_pit down(n + 1) }\ndown(0)",
+			want:    ErrCallDepth,
+			message: "maximum call depth exceeded (1000 calls)",
+		},
+		{
+			name:    "configured call depth",
+			limits:  Limits{MaxDepth: 50},
+			input:   "pace down(n) { return_pit down(n + 1) }\ndown(0)",
+			want:    ErrCallDepth,
+			message: "maximum call depth exceeded (50 calls)",
+		},
+		{
+			name:    "memory limit",
+			limits:  Limits{MaxMemory: 1 << 20},
+			input:   `pace grow(s) { return_pit grow(s + s) }` + "\n" + `grow("lap")`,
+			want:    ErrMemoryLimit,
+			message: "memory limit exceeded (1048576 bytes)",
+		},
+		{
+			name:    "memory limit counts builtin results",
+			limits:  Limits{MaxMemory: 1 << 16},
+			input:   "grid laps = []\nwhile_racing (true) { laps = push(laps, 1) }",
+			want:    ErrMemoryLimit,
+			message: "memory limit exceeded (65536 bytes)",
+		},
+		{
+			name:    "memory limit counts map growth",
+			limits:  Limits{MaxMemory: 1 << 20},
+			input:   "grid m = {}\ngrid i = 0\nwhile_racing (i < 2000000) { m[i] = i\ni++ }",
+			want:    ErrMemoryLimit,
+			message: "memory limit exceeded (1048576 bytes)",
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		for _, backend := range backends(&out) {
+			t.Run(fmt.Sprintf("%s/%T", tt.name, backend), func(t *testing.T) {
+				out.Reset()
+				*backend.Resources() = tt.limits
+
+				err := backend.Execute(tt.input)
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("error = %v, want %v", err, tt.want)
+				}
+				var rt *RuntimeError
+				if !errors.As(err, &rt) || rt.Message != tt.message {
+					t.Errorf("message = %q, want %q", rt.Message, tt.message)
+				}
+				if out.Len() > 0 {
+					t.Errorf("unexpected output %q", out.String())
+				}
+			})
+		}
+	}
+}
+
+func TestLimitsAreRecoverable(t *testing.T) {
+	input := `
+pace down(n) { return_pit down(n + 1) }
+safety_car { down(0) } recover (err) { telemetry(err.message) }
+
+pace grow(s) { return_pit grow(s + s) }
+safety_car { grow("lap") } recover (err) { telemetry(err.message) }
+`
+	var out bytes.Buffer
+	for _, backend := range backends(&out) {
+		out.Reset()
+		*backend.Resources() = Limits{MaxDepth: 100, MaxMemory: 1 << 20}
+
+		if err := backend.Execute(input); err != nil {
+			t.Fatalf("%T: %v", backend, err)
+		}
+		want := "maximum call depth exceeded (100 calls)\nmemory limit exceeded (1048576 bytes)\n"
+		if got := out.String(); got != want {
+			t.Errorf("%T output = %q, want %q", backend, got, want)
+		}
+	}
+}
+
+func TestLimitsAllowDeepRecursion(t *testing.T) {
+	input := "pace depth(n) { circuit (n == 0) { return_pit 0 } return_pit 1 + depth(n - 1) }\ntelemetry(depth(5000))"
+
+	var out bytes.Buffer
+	for _, backend := range backends(&out) {
+		out.Reset()
+		*backend.Resources() = Limits{MaxDepth: 6000}
+
+		if err := backend.Execute(input); err != nil {
+			t.Fatalf("%T: %v", backend, err)
+		}
+		if got := out.String(); got != "5000\n" {
+			t.Errorf("%T output = %q, want 5000", backend, got)
+		}
+	}
+}
+
+func TestExecuteContext(t *testing.T) {
+	var out bytes.Buffer
+	for _, backend := range backends(&out) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := backend.ExecuteContext(ctx, "while_racing (true) { }")
+		cancel()
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%T error = %v, want %v", backend, err, context.DeadlineExceeded)
+		}
+		if !strings.Contains(err.Error(), "execution cancelled: context deadline exceeded") {
+			t.Errorf("%T error = %q", backend, err)
+		}
+
+		if err := backend.Execute("pace spin() { while_racing (true) { } }"); err != nil {
+			t.Fatalf("%T: %v", backend, err)
+		}
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		if _, err := backend.CallContext(ctx, "spin"); !errors.Is(err, context.Canceled) {
+			t.Errorf("%T CallContext error = %v, want %v", backend, err, context.Canceled)
+		}
+	}
+}
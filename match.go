@@ -0,0 +1,193 @@
+package alonso
+
+// matchPattern tests value against pattern, as a match arm does. garages
+// holds the garage each struct pattern names, as patternGarage found it.
+// When value matches, it returns the values of the names the pattern
+// binds, in the order patternNames lists them. The interpreter and the VM
+// share it, so both match exactly the same values.
+func matchPattern(pattern Pattern, value Object, garages map[*StructPattern]*Garage) ([]Object, bool) {
+	m := matcher{garages: garages}
+	if !m.match(pattern, value) {
+		return nil, false
+	}
+	return m.bound, true
+}
+
+type matcher struct {
+	garages map[*StructPattern]*Garage
+	bound   []Object
+}
+
+func (m *matcher) match(pattern Pattern, value Object) bool {
+	switch pattern := pattern.(type) {
+	case *WildcardPattern:
+		return true
+
+	case *BindingPattern:
+		m.bound = append(m.bound, value)
+		return true
+
+	case *LiteralPattern:
+		return objectsEqual(value, literalObject(pattern.Value))
+
+	case *RangePattern:
+		return inCaseRange(value, literalObject(pattern.Start), literalObject(pattern.End)) == TRUE
+
+	case *ArrayPattern:
+		elements, rest, ok := splitSequence(value, len(pattern.Elements), pattern.Rest == nil)
+		if !ok {
+			return false
+		}
+		for idx, element := range pattern.Elements {
+			if !m.match(element, elements[idx]) {
+				return false
+			}
+		}
+		if pattern.Rest != nil {
+			return m.match(pattern.Rest, rest)
+		}
+		return true
+
+	case *MapPattern:
+		mapValue, ok := value.(*Map)
+		if !ok {
+			return false
+		}
+		for idx, key := range pattern.Keys {
+			entry, ok := mapValue.Get(literalObject(key).(Hashable))
+			if !ok || !m.match(pattern.Values[idx], entry) {
+				return false
+			}
+		}
+		return true
+
+	case *StructPattern:
+		instance, ok := value.(*Struct)
+		if !ok || instance.Garage != m.garages[pattern] {
+			return false
+		}
+		for idx, field := range pattern.Fields {
+			if !m.match(pattern.Values[idx], instance.Fields[field.Value]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// patternNames lists the names pattern binds, in the order matchPattern
+// returns their values.
+func patternNames(pattern Pattern) []string {
+	var names []string
+	var walk func(Pattern)
+	walk = func(pattern Pattern) {
+		switch pattern := pattern.(type) {
+		case *BindingPattern:
+			names = append(names, pattern.Name.Value)
+		case *ArrayPattern:
+			for _, element := range pattern.Elements {
+				walk(element)
+			}
+			if pattern.Rest != nil {
+				walk(pattern.Rest)
+			}
+		case *MapPattern:
+			for _, value := range pattern.Values {
+				walk(value)
+			}
+		case *StructPattern:
+			for _, value := range pattern.Values {
+				walk(value)
+			}
+		}
+	}
+	walk(pattern)
+	return names
+}
+
+// structPatterns lists the struct patterns in pattern, whose garages are
+// looked up by name each time the arm is tried.
+func structPatterns(pattern Pattern) []*StructPattern {
+	var structs []*StructPattern
+	var walk func(Pattern)
+	walk = func(pattern Pattern) {
+		switch pattern := pattern.(type) {
+		case *ArrayPattern:
+			for _, element := range pattern.Elements {
+				walk(element)
+			}
+		case *MapPattern:
+			for _, value := range pattern.Values {
+				walk(value)
+			}
+		case *StructPattern:
+			structs = append(structs, pattern)
+			for _, value := range pattern.Values {
+				walk(value)
+			}
+		}
+	}
+	walk(pattern)
+	return structs
+}
+
+// patternGarage checks that val, the value of the name a struct pattern
+// begins with, is a garage with every field the pattern names.
+func patternGarage(pattern *StructPattern, val Object) (*Garage, *Error) {
+	garage, ok := val.(*Garage)
+	if !ok {
+		return nil, newError("%s in a pattern must be GARAGE, got %s", pattern.Garage.Value, val.Type())
+	}
+	for _, field := range pattern.Fields {
+		if !garage.HasField(field.Value) {
+			return nil, newError("garage %s has no field %s", garage.Name, field.Value)
+		}
+	}
+	return garage, nil
+}
+
+// splitSequence returns the first n elements of a formation, or the first
+// n characters of a string, and a formation or string of the rest. It
+// fails for other values, for sequences shorter than n and, when exact,
+// for longer ones.
+func splitSequence(value Object, n int, exact bool) ([]Object, Object, bool) {
+	switch value := value.(type) {
+	case *Array:
+		if len(value.Elements) < n || (exact && len(value.Elements) > n) {
+			return nil, nil, false
+		}
+		rest := make([]Object, len(value.Elements)-n)
+		copy(rest, value.Elements[n:])
+		return value.Elements[:n], &Array{Elements: rest}, true
+
+	case *String:
+		chars := []rune(value.Value)
+		if len(chars) < n || (exact && len(chars) > n) {
+			return nil, nil, false
+		}
+		elements := make([]Object, n)
+		for idx, ch := range chars[:n] {
+			elements[idx] = &String{Value: string(ch)}
+		}
+		return elements, &String{Value: string(chars[n:])}, true
+	}
+
+	return nil, nil, false
+}
+
+// literalObject is the value of a literal in a pattern.
+func literalObject(lit Expression) Object {
+	switch lit := lit.(type) {
+	case *IntegerLiteral:
+		return newInteger(lit.Value)
+	case *NumberLiteral:
+		return &Number{Value: lit.Value}
+	case *StringLiteral:
+		return &String{Value: lit.Value}
+	case *BooleanLiteral:
+		return nativeBoolToBooleanObject(lit.Value)
+	}
+	return NULL
+}
@@ -0,0 +1,79 @@
+package alonso
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// moduleLoader finds, parses and caches the files a program imports. Both
+// backends share it and differ only in how they run a module once loaded.
+type moduleLoader struct {
+	modules map[string]*Module // imported modules by absolute path
+	loading []string           // modules currently being loaded
+}
+
+func newModuleLoader() *moduleLoader {
+	return &moduleLoader{modules: make(map[string]*Module)}
+}
+
+// load returns the module at importPath, relative to the file from. The
+// first import of a file parses it and hands it to run, which runs it and
+// returns the module it made of it, or an error. Later imports return the
+// same module.
+func (l *moduleLoader) load(from, importPath string, sources map[string]string, run func(file string, program *Program) Object) Object {
+	file := importPath
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(from), file)
+	}
+
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return newError("cannot import %q: %s", importPath, err)
+	}
+
+	if module, ok := l.modules[path]; ok {
+		return module
+	}
+
+	for idx, loading := range l.loading {
+		if loading == path {
+			cycle := []string{}
+			for _, p := range append(l.loading[idx:], path) {
+				cycle = append(cycle, filepath.Base(p))
+			}
+			return newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return newError("cannot import %q: %s", importPath, err)
+	}
+
+	parser := NewParser(NewLexer(string(content)))
+	program := parser.ParseProgram()
+	if len(parser.Errors()) > 0 {
+		diagnostics := []string{}
+		for _, err := range parser.Diagnostics() {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		return newError("cannot import %q: %s", importPath, strings.Join(diagnostics, "; "))
+	}
+
+	sources[file] = string(content)
+	l.loading = append(l.loading, path)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	result := run(file, program)
+	module, ok := result.(*Module)
+	if !ok {
+		return result
+	}
+
+	module.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	module.Path = path
+	l.modules[path] = module
+
+	return module
+}
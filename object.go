@@ -1,13 +1,17 @@
-package main
+package alonso
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"strconv"
 	"strings"
 )
 
 type ObjectType string
 
 const (
+	INTEGER_OBJ  = "INTEGER"
 	NUMBER_OBJ   = "NUMBER"
 	STRING_OBJ   = "STRING"
 	BOOLEAN_OBJ  = "BOOLEAN"
@@ -17,8 +21,15 @@ const (
 	FUNCTION_OBJ = "FUNCTION"
 	BUILTIN_OBJ  = "BUILTIN"
 	ARRAY_OBJ    = "ARRAY"
+	MAP_OBJ      = "MAP"
 	BREAK_OBJ    = "BREAK"
 	CONTINUE_OBJ = "CONTINUE"
+	GARAGE_OBJ   = "GARAGE"
+	STRUCT_OBJ   = "STRUCT"
+	MODULE_OBJ   = "MODULE"
+	ITERATOR_OBJ = "ITERATOR"
+	PATTERN_OBJ  = "PATTERN"
+	SCOPE_OBJ    = "SCOPE"
 )
 
 type Object interface {
@@ -26,12 +37,218 @@ type Object interface {
 	Inspect() string
 }
 
+// HashKey identifies a map key by type and value, so that two distinct
+// objects holding the same value address the same map entry. Strings keep
+// their whole value in Text, so different strings never share an entry.
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+	Text  string
+}
+
+// Hashable is implemented by objects that can be used as map keys.
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+// objectsEqual defines == for every object type. Numbers, strings, booleans
+// and null compare by value, integers and floats with each other; arrays,
+// maps and structs compare their contents (structs must also share a
+// garage); functions, builtins, garages and modules are only equal to
+// themselves. Values of different types are never equal.
+func objectsEqual(left, right Object) bool {
+	return compareObjects(left, right, nil)
+}
+
+// objectPair records containers already being compared, so that values that
+// contain themselves do not recurse forever.
+type objectPair struct {
+	left, right Object
+}
+
+func compareObjects(left, right Object, seen map[objectPair]bool) bool {
+	switch l := left.(type) {
+	case *Integer, *Number:
+		return isNumeric(right) && compareNumbers(l, right) == 0
+	case *String:
+		r, ok := right.(*String)
+		return ok && l.Value == r.Value
+	case *Boolean:
+		r, ok := right.(*Boolean)
+		return ok && l.Value == r.Value
+	case *Null:
+		_, ok := right.(*Null)
+		return ok
+	}
+
+	if left == right {
+		return true
+	}
+
+	pair := objectPair{left, right}
+	if seen[pair] {
+		return true
+	}
+
+	switch l := left.(type) {
+	case *Array:
+		r, ok := right.(*Array)
+		if !ok || len(l.Elements) != len(r.Elements) {
+			return false
+		}
+		seen = markSeen(seen, pair)
+		for idx := range l.Elements {
+			if !compareObjects(l.Elements[idx], r.Elements[idx], seen) {
+				return false
+			}
+		}
+		return true
+	case *Map:
+		r, ok := right.(*Map)
+		if !ok || len(l.Pairs) != len(r.Pairs) {
+			return false
+		}
+		seen = markSeen(seen, pair)
+		for hash, entry := range l.Pairs {
+			other, ok := r.Pairs[hash]
+			if !ok || !compareObjects(entry.Value, other.Value, seen) {
+				return false
+			}
+		}
+		return true
+	case *Struct:
+		r, ok := right.(*Struct)
+		if !ok || l.Garage != r.Garage {
+			return false
+		}
+		seen = markSeen(seen, pair)
+		for _, name := range l.Garage.Fields {
+			if !compareObjects(l.Fields[name], r.Fields[name], seen) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+func markSeen(seen map[objectPair]bool, pair objectPair) map[objectPair]bool {
+	if seen == nil {
+		seen = make(map[objectPair]bool)
+	}
+	seen[pair] = true
+	return seen
+}
+
+type Integer struct {
+	Value int64
+}
+
+func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
+func (i *Integer) Inspect() string  { return strconv.FormatInt(i.Value, 10) }
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+// Integers in this range are served from a shared table instead of
+// allocating a fresh *Integer for every result.
+const integerCacheSize = 1024
+
+var integerCache = func() [integerCacheSize]*Integer {
+	var cache [integerCacheSize]*Integer
+	for i := range cache {
+		cache[i] = &Integer{Value: int64(i)}
+	}
+	return cache
+}()
+
+func newInteger(value int64) *Integer {
+	if value >= 0 && value < integerCacheSize {
+		return integerCache[value]
+	}
+	return &Integer{Value: value}
+}
+
+// Number is a 64-bit floating point number.
 type Number struct {
 	Value float64
 }
 
 func (n *Number) Type() ObjectType { return NUMBER_OBJ }
 func (n *Number) Inspect() string  { return fmt.Sprintf("%g", n.Value) }
+func (n *Number) HashKey() HashKey {
+	if i, ok := floatToInt(n.Value); ok {
+		return (&Integer{Value: i}).HashKey() // 2.0 and 2 are the same key
+	}
+	return HashKey{Type: n.Type(), Value: math.Float64bits(n.Value)}
+}
+
+// isNumeric reports whether obj is an Integer or a Number.
+func isNumeric(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *Number:
+		return true
+	}
+	return false
+}
+
+// toFloat returns the value of an Integer or Number as a float64.
+func toFloat(obj Object) float64 {
+	if i, ok := obj.(*Integer); ok {
+		return float64(i.Value)
+	}
+	return obj.(*Number).Value
+}
+
+// floatToInt returns f as an int64 if it is a whole number in range.
+func floatToInt(f float64) (int64, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// compareNumbers returns -1, 0 or 1 as left is less than, equal to or
+// greater than right, and 2 if either is NaN. Whole numbers compare exactly,
+// so large integers do not lose precision against floats.
+func compareNumbers(left, right Object) int {
+	l, lWhole := wholeNumber(left)
+	r, rWhole := wholeNumber(right)
+	if lWhole && rWhole {
+		switch {
+		case l < r:
+			return -1
+		case l > r:
+			return 1
+		}
+		return 0
+	}
+
+	a, b := toFloat(left), toFloat(right)
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	case a == b:
+		return 0
+	}
+	return 2
+}
+
+// wholeNumber returns the value of an Integer, or of a Number holding a
+// whole number in the range of an Integer.
+func wholeNumber(obj Object) (int64, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return obj.Value, true
+	case *Number:
+		return floatToInt(obj.Value)
+	}
+	return 0, false
+}
 
 type String struct {
 	Value string
@@ -39,6 +256,9 @@ type String struct {
 
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
+func (s *String) HashKey() HashKey {
+	return HashKey{Type: s.Type(), Text: s.Value}
+}
 
 type Boolean struct {
 	Value bool
@@ -46,6 +266,13 @@ type Boolean struct {
 
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%t", b.Value) }
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{Type: b.Type(), Value: value}
+}
 
 type Null struct{}
 
@@ -61,15 +288,21 @@ func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
 type Error struct {
 	Message string
+	File    string
+	Pos     Position     // where the error was raised; zero until located
+	Trace   []TraceFrame // active calls when the error was raised, outermost first
+	Cause   error        // the exceeded limit or context error, if that stopped the program
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 
 type Function struct {
+	Name       string
 	Parameters []*Identifier
 	Body       *BlockStatement
 	Env        *Environment
+	File       string // file the pace was defined in
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -81,8 +314,77 @@ func (f *Function) Inspect() string {
 	return fmt.Sprintf("pace(%s) {\n%s\n}", strings.Join(params, ", "), f.Body.String())
 }
 
+// CompiledFunction is a pace body lowered to bytecode by the Compiler.
+type CompiledFunction struct {
+	Name          string
+	Instructions  Instructions
+	Positions     SourceMap
+	NumParameters int
+	SlotNames     []string // one entry per local slot, parameters first
+	Parameters    []string
+	Body          string
+	File          string // file the pace was compiled from
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("pace(%s) {\n%s\n}", strings.Join(cf.Parameters, ", "), cf.Body)
+}
+
+// Scope holds the local slots of one running function. Closures keep a
+// pointer to the scope they were created in, so captured variables are shared
+// rather than copied, just like an Environment.
+type Scope struct {
+	Slots []Object
+	Names []string
+	Outer *Scope
+}
+
+type Closure struct {
+	Fn  *CompiledFunction
+	Env *Scope
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string  { return c.Fn.Inspect() }
+
+// Caller calls a function value on behalf of a builtin, so that builtins
+// such as map can call back into user code on either backend.
+type Caller func(fn Object, args ...Object) Object
+
+// Host is what a builtin sees of the backend running it.
+type Host struct {
+	*Streams
+	Call Caller
+
+	meter *meter // counts output against Limits.MaxOutput
+}
+
+// Print writes s to Stdout. Past Limits.MaxOutput it writes only what fits
+// and returns an error.
+func (h *Host) Print(s string) *Error {
+	if h.meter != nil {
+		if fits, err := h.meter.output(len(s)); err != nil {
+			io.WriteString(h.Stdout, s[:fits])
+			return err
+		}
+	}
+	io.WriteString(h.Stdout, s)
+	return nil
+}
+
 type Builtin struct {
 	Fn func(args ...Object) Object
+	// HostFn replaces Fn for builtins that do I/O or call the functions
+	// they are given.
+	HostFn func(host *Host, args ...Object) Object
+}
+
+func (b *Builtin) Call(host *Host, args []Object) Object {
+	if b.HostFn != nil {
+		return b.HostFn(host, args...)
+	}
+	return b.Fn(args...)
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -93,20 +395,220 @@ type Array struct {
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
-	elements := []string{}
-	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+func (ao *Array) Inspect() string  { return inspect(ao, nil) }
+
+type MapEntry struct {
+	Key   Object
+	Value Object
+}
+
+// Map is a hash map keyed by Hashable objects. It remembers insertion order
+// so that Inspect, keys and values are deterministic.
+type Map struct {
+	Pairs map[HashKey]MapEntry
+	order []HashKey
+}
+
+func NewMap() *Map {
+	return &Map{Pairs: make(map[HashKey]MapEntry)}
+}
+
+func (m *Map) Type() ObjectType { return MAP_OBJ }
+func (m *Map) Inspect() string  { return inspect(m, nil) }
+
+func (m *Map) Get(key Hashable) (Object, bool) {
+	entry, ok := m.Pairs[key.HashKey()]
+	if !ok {
+		return nil, false
+	}
+	return entry.Value, true
+}
+
+func (m *Map) Set(key Hashable, val Object) {
+	hash := key.HashKey()
+	if _, exists := m.Pairs[hash]; !exists {
+		m.order = append(m.order, hash)
+	}
+	m.Pairs[hash] = MapEntry{Key: key, Value: val}
+}
+
+func (m *Map) Delete(key Hashable) bool {
+	hash := key.HashKey()
+	if _, exists := m.Pairs[hash]; !exists {
+		return false
+	}
+	delete(m.Pairs, hash)
+	for idx, h := range m.order {
+		if h == hash {
+			m.order = append(m.order[:idx], m.order[idx+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Entries returns the key/value pairs in insertion order.
+func (m *Map) Entries() []MapEntry {
+	entries := make([]MapEntry, 0, len(m.order))
+	for _, hash := range m.order {
+		entries = append(entries, m.Pairs[hash])
+	}
+	return entries
+}
+
+// Garage is a user-defined struct type. Calling it builds a Struct.
+type Garage struct {
+	Name   string
+	Fields []string
+}
+
+func (g *Garage) Type() ObjectType { return GARAGE_OBJ }
+func (g *Garage) Inspect() string {
+	return fmt.Sprintf("garage %s { %s }", g.Name, strings.Join(g.Fields, ", "))
+}
+
+func (g *Garage) HasField(name string) bool {
+	for _, f := range g.Fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Struct is an instance of a Garage. Instances are shared by reference, so
+// assigning a field is visible through every binding of the same value.
+type Struct struct {
+	Garage *Garage
+	Fields map[string]Object
+}
+
+func (s *Struct) Type() ObjectType { return STRUCT_OBJ }
+func (s *Struct) Inspect() string  { return inspect(s, nil) }
+
+// inspect renders arrays, maps and structs with their contents. A container
+// that is already being printed further out is shown as [...] or {...}, so
+// values that contain themselves do not recurse forever.
+func inspect(obj Object, seen map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		if seen[obj] {
+			return "[...]"
+		}
+		seen = markInspected(seen, obj)
+		defer delete(seen, obj)
+
+		elements := []string{}
+		for _, e := range obj.Elements {
+			elements = append(elements, inspect(e, seen))
+		}
+		return fmt.Sprintf("[%s]", strings.Join(elements, ", "))
+	case *Map:
+		if seen[obj] {
+			return "{...}"
+		}
+		seen = markInspected(seen, obj)
+		defer delete(seen, obj)
+
+		pairs := []string{}
+		for _, entry := range obj.Entries() {
+			pairs = append(pairs, fmt.Sprintf("%s: %s", entry.Key.Inspect(), inspect(entry.Value, seen)))
+		}
+		return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
+	case *Struct:
+		if seen[obj] {
+			return obj.Garage.Name + "{...}"
+		}
+		seen = markInspected(seen, obj)
+		defer delete(seen, obj)
+
+		fields := []string{}
+		for _, name := range obj.Garage.Fields {
+			fields = append(fields, fmt.Sprintf("%s: %s", name, inspect(obj.Fields[name], seen)))
+		}
+		return fmt.Sprintf("%s{%s}", obj.Garage.Name, strings.Join(fields, ", "))
 	}
-	return fmt.Sprintf("[%s]", strings.Join(elements, ", "))
+	return obj.Inspect()
+}
+
+func markInspected(seen map[Object]bool, obj Object) map[Object]bool {
+	if seen == nil {
+		seen = make(map[Object]bool)
+	}
+	seen[obj] = true
+	return seen
+}
+
+// Module is the namespace produced by an import. Its members are the
+// top-level bindings of the imported file: those of Env when the
+// interpreter ran it, or the slots of scope when the VM did.
+type Module struct {
+	Name string
+	Path string
+	Env  *Environment
+
+	scope   *Scope
+	members map[string]int // slot of each member in scope
+}
+
+// Member returns the top-level binding name of the module.
+func (m *Module) Member(name string) (Object, bool) {
+	if m.scope == nil {
+		return m.Env.GetOwn(name)
+	}
+	idx, ok := m.members[name]
+	if !ok || m.scope.Slots[idx] == nil {
+		return nil, false
+	}
+	return m.scope.Slots[idx], true
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return fmt.Sprintf("<module %s>", m.Name) }
+
+// Iterator steps through the value a `loop (x in ...)` statement runs
+// over. Next returns the index or key and the value of each element in
+// turn, and false once there are none left.
+type Iterator struct {
+	Next func() (key, value Object, ok bool)
 }
 
-type Break struct{}
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return "<iterator>" }
+
+// MatchPattern is the pattern of a match arm, kept among the constants of
+// compiled code for OpMatch to test values against.
+type MatchPattern struct {
+	Pattern Pattern
+	Structs []*StructPattern // in the order OpMatch finds their garages on the stack
+}
+
+func (mp *MatchPattern) Type() ObjectType { return PATTERN_OBJ }
+func (mp *MatchPattern) Inspect() string  { return mp.Pattern.String() }
+
+// ScopeLayout names the slots of the scope OpEnterScope opens for a block
+// or for each pass of a `loop (x in ...)`, kept among the constants of
+// compiled code.
+type ScopeLayout struct {
+	SlotNames []string
+}
+
+func (sl *ScopeLayout) Type() ObjectType { return SCOPE_OBJ }
+func (sl *ScopeLayout) Inspect() string  { return "<scope>" }
+
+// Break and Continue carry a break_flag or continue_race out through the
+// blocks around it to the loop it is for: the one labelled Label, or the
+// innermost when Label is empty.
+type Break struct {
+	Label string
+}
 
 func (b *Break) Type() ObjectType { return BREAK_OBJ }
 func (b *Break) Inspect() string  { return "break_flag" }
 
-type Continue struct{}
+type Continue struct {
+	Label string
+}
 
 func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
 func (c *Continue) Inspect() string  { return "continue_race" }
@@ -118,8 +620,7 @@ type Environment struct {
 }
 
 func NewEnvironment() *Environment {
-	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	return &Environment{}
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -136,7 +637,31 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return value, ok
 }
 
+// GetOwn looks name up in this environment only, ignoring outer scopes.
+func (e *Environment) GetOwn(name string) (Object, bool) {
+	value, ok := e.store[name]
+	return value, ok
+}
+
+// Set declares name in this environment, shadowing any binding of it in an
+// outer scope. The store is made on first use, since most block scopes
+// declare nothing.
 func (e *Environment) Set(name string, val Object) Object {
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
 	e.store[name] = val
 	return val
 }
+
+// Assign rebinds name in the innermost environment that declares it and
+// reports whether there was one.
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return true
+		}
+	}
+	return false
+}
@@ -1,15 +1,34 @@
-package main
+package alonso
 
 import (
 	"fmt"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type Parser struct {
 	lexer        *Lexer
 	currentToken Token
 	peekToken    Token
-	errors       []string
+	errors       []*SyntaxError
+
+	depth      int   // braces opened and not yet closed before currentToken
+	parens     int   // likewise for parentheses
+	recovering bool  // the current statement has a syntax error
+	failedAt   Token // the token that error was found at
+
+	loops   []string // labels of the loops around the current statement, "" if unlabelled
+	armBody Token    // the token a match arm body begins at
+}
+
+// statementKeywords are the tokens that can only begin a statement, so a
+// statement being skipped after a syntax error cannot run past them.
+var statementKeywords = map[TokenType]bool{
+	GRID: true, GARAGE: true, IMPORT: true, CIRCUIT: true, LOOP: true, WHILE_RACING: true,
+	RETURN_PIT: true, BREAK_FLAG: true, CONTINUE_RACE: true, SAFETY_CAR: true,
+	STRATEGY: true, CASE: true, DEFAULT: true,
 }
 
 type PrecedenceLevel int
@@ -28,28 +47,36 @@ const (
 )
 
 var precedences = map[TokenType]PrecedenceLevel{
-	AND:           LOGICAL,
-	OR:            LOGICAL,
-	EQUAL:         EQUALS,
-	NOT_EQUAL:     EQUALS,
-	LESS:          LESSGREATER,
-	GREATER:       LESSGREATER,
-	LESS_EQUAL:    LESSGREATER,
-	GREATER_EQUAL: LESSGREATER,
-	PLUS:          SUM,
-	MINUS:         SUM,
-	DIVIDE:        PRODUCT,
-	MULTIPLY:      PRODUCT,
-	MODULO:        PRODUCT,
-	ASSIGN:        EQUALS,
-	LPAREN:        CALL,
-	LBRACKET:      INDEX,
+	AND:             LOGICAL,
+	OR:              LOGICAL,
+	EQUAL:           EQUALS,
+	NOT_EQUAL:       EQUALS,
+	LESS:            LESSGREATER,
+	GREATER:         LESSGREATER,
+	LESS_EQUAL:      LESSGREATER,
+	GREATER_EQUAL:   LESSGREATER,
+	PLUS:            SUM,
+	MINUS:           SUM,
+	DIVIDE:          PRODUCT,
+	MULTIPLY:        PRODUCT,
+	MODULO:          PRODUCT,
+	ASSIGN:          EQUALS,
+	PLUS_ASSIGN:     EQUALS,
+	MINUS_ASSIGN:    EQUALS,
+	MULTIPLY_ASSIGN: EQUALS,
+	DIVIDE_ASSIGN:   EQUALS,
+	MODULO_ASSIGN:   EQUALS,
+	INCREMENT:       INDEX,
+	DECREMENT:       INDEX,
+	LPAREN:          CALL,
+	LBRACKET:        INDEX,
+	DOT:             INDEX,
 }
 
 func NewParser(lexer *Lexer) *Parser {
 	p := &Parser{
 		lexer:  lexer,
-		errors: []string{},
+		errors: []*SyntaxError{},
 	}
 
 	// Read two tokens, so currentToken and peekToken are both set
@@ -60,6 +87,17 @@ func NewParser(lexer *Lexer) *Parser {
 }
 
 func (p *Parser) nextToken() {
+	switch p.currentToken.Type {
+	case LBRACE:
+		p.depth++
+	case RBRACE:
+		p.depth--
+	case LPAREN:
+		p.parens++
+	case RPAREN:
+		p.parens--
+	}
+
 	p.currentToken = p.peekToken
 	p.peekToken = p.lexer.NextToken()
 }
@@ -75,49 +113,180 @@ func (p *Parser) ParseProgram() *Program {
 			continue
 		}
 
-		stmt := p.parseStatement()
-		if stmt != nil {
-			program.Statements = append(program.Statements, stmt)
-		}
+		program.Statements = append(program.Statements, p.parseStatementOrSkip())
 		p.nextToken()
 	}
 
 	return program
 }
 
+// parseStatementOrSkip parses the statement at the current token. One with
+// a syntax error is skipped and replaced by a BadStatement, so that each
+// mistake is reported once and parsing carries on after it.
+func (p *Parser) parseStatementOrSkip() Statement {
+	start, depth, parens := p.currentToken, p.depth, p.parens
+
+	stmt := p.parseStatement()
+	if !p.recovering {
+		return stmt
+	}
+
+	p.synchronize(depth, parens)
+	p.recovering = false
+	return &BadStatement{Token: start}
+}
+
+// synchronize skips the rest of a statement that failed to parse, given
+// the brace and parenthesis depths it began at. It stops before the next
+// newline outside the braces the statement opened, before a semicolon or
+// a keyword that begins another statement outside its braces and
+// parentheses, and before the brace closing the enclosing block, leaving
+// the caller's nextToken to move on to what follows.
+func (p *Parser) synchronize(depth, parens int) {
+	switch p.currentToken.Type {
+	case NEWLINE, SEMICOLON, RBRACE, EOF:
+		if p.currentToken == p.failedAt {
+			return // the mistake was a missing expression before it
+		}
+	}
+
+	for p.peekToken.Type != EOF {
+		nested, grouped := p.depth-depth, p.parens-parens
+		switch p.currentToken.Type {
+		case LBRACE:
+			nested++
+		case RBRACE:
+			nested--
+		case LPAREN:
+			grouped++
+		case RPAREN:
+			grouped--
+		}
+
+		if nested <= 0 {
+			switch p.peekToken.Type {
+			case NEWLINE, RBRACE:
+				return
+			case SEMICOLON:
+				if grouped <= 0 {
+					return
+				}
+			}
+			if statementKeywords[p.peekToken.Type] && grouped <= 0 && p.peekToken != p.failedAt {
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseStatement() Statement {
 	switch p.currentToken.Type {
 	case GRID:
 		return p.parseGridStatement()
 	case PACE:
+		if p.peekToken.Type == LPAREN {
+			return p.parseExpressionStatement()
+		}
 		return p.parsePaceStatement()
+	case GARAGE:
+		return p.parseGarageStatement()
+	case IMPORT:
+		return p.parseImportStatement()
 	case CIRCUIT:
 		return p.parseCircuitStatement()
+	case STRATEGY:
+		return p.parseStrategyStatement()
 	case LOOP:
-		return p.parseLoopStatement()
+		return p.parseLoopStatement(nil)
 	case WHILE_RACING:
-		return p.parseWhileRacingStatement()
+		return p.parseWhileRacingStatement(nil)
 	case RETURN_PIT:
 		return p.parseReturnPitStatement()
 	case BREAK_FLAG:
 		return p.parseBreakFlagStatement()
 	case CONTINUE_RACE:
 		return p.parseContinueRaceStatement()
+	case SAFETY_CAR:
+		return p.parseSafetyCarStatement()
 	case LBRACE:
 		return p.parseBlockStatement()
+	case IDENTIFIER:
+		if p.peekToken.Type == COLON {
+			return p.parseLabeledStatement()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
+// parseLabeledStatement parses `name: loop ...` or `name: while_racing ...`,
+// a loop that break_flag and continue_race inside it can name.
+func (p *Parser) parseLabeledStatement() Statement {
+	label := &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
+	p.nextToken()
+
+	for _, name := range p.loops {
+		if name == label.Value {
+			p.addError(label.Token, nil, "label %s is already used by an enclosing loop", label.Value)
+			return nil
+		}
+	}
+
+	switch p.peekToken.Type {
+	case LOOP:
+		p.nextToken()
+		return p.parseLoopStatement(label)
+	case WHILE_RACING:
+		p.nextToken()
+		return p.parseWhileRacingStatement(label)
+	default:
+		p.peekError(LOOP, WHILE_RACING)
+		return nil
+	}
+}
+
+func (p *Parser) parseSafetyCarStatement() *SafetyCarStatement {
+	stmt := &SafetyCarStatement{Token: p.currentToken}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	p.skipPeekNewlines()
+	if !p.expectPeek(RECOVER) {
+		return nil
+	}
+
+	if p.peekToken.Type == LPAREN {
+		p.nextToken()
+		if !p.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		stmt.Param = &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
+		if !p.expectPeek(RPAREN) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+	stmt.Handler = p.parseBlockStatement()
+
+	return stmt
+}
+
 func (p *Parser) parseGridStatement() *GridStatement {
-	stmt := &GridStatement{}
+	stmt := &GridStatement{Token: p.currentToken}
 
 	if !p.expectPeek(IDENTIFIER) {
 		return nil
 	}
 
-	stmt.Name = &Identifier{Value: p.currentToken.Value}
+	stmt.Name = &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
 
 	if !p.expectPeek(ASSIGN) {
 		return nil
@@ -125,6 +294,7 @@ func (p *Parser) parseGridStatement() *GridStatement {
 
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
+	nameFunction(stmt.Value, stmt.Name.Value)
 
 	if p.peekToken.Type == SEMICOLON {
 		p.nextToken()
@@ -134,13 +304,13 @@ func (p *Parser) parseGridStatement() *GridStatement {
 }
 
 func (p *Parser) parsePaceStatement() *PaceStatement {
-	stmt := &PaceStatement{}
+	stmt := &PaceStatement{Token: p.currentToken}
 
 	if !p.expectPeek(IDENTIFIER) {
 		return nil
 	}
 
-	stmt.Name = &Identifier{Value: p.currentToken.Value}
+	stmt.Name = &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
 
 	if !p.expectPeek(LPAREN) {
 		return nil
@@ -152,11 +322,59 @@ func (p *Parser) parsePaceStatement() *PaceStatement {
 		return nil
 	}
 
-	stmt.Body = p.parseBlockStatement()
+	stmt.Body = p.parseFunctionBody()
 
 	return stmt
 }
 
+func (p *Parser) parsePaceLiteral() Expression {
+	lit := &PaceLiteral{Token: p.currentToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	lit.Parameters = p.parseFunctionParameters()
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+
+	lit.Body = p.parseFunctionBody()
+
+	return lit
+}
+
+// parseFunctionBody parses the body of a pace, which break_flag and
+// continue_race cannot leave, even when it is written inside a loop.
+func (p *Parser) parseFunctionBody() *BlockStatement {
+	loops := p.loops
+	p.loops = nil
+	defer func() { p.loops = loops }()
+
+	return p.parseBlockStatement()
+}
+
+// parseLoopBody parses the body of a loop with the given label, if any.
+func (p *Parser) parseLoopBody(label *Identifier) *BlockStatement {
+	name := ""
+	if label != nil {
+		name = label.Value
+	}
+	p.loops = append(p.loops, name)
+	defer func() { p.loops = p.loops[:len(p.loops)-1] }()
+
+	return p.parseBlockStatement()
+}
+
+// nameFunction gives an anonymous pace the name it is bound to, so that
+// tracebacks can refer to it.
+func nameFunction(value Expression, name string) {
+	if lit, ok := value.(*PaceLiteral); ok && lit.Name == "" {
+		lit.Name = name
+	}
+}
+
 func (p *Parser) parseFunctionParameters() []*Identifier {
 	identifiers := []*Identifier{}
 
@@ -167,25 +385,113 @@ func (p *Parser) parseFunctionParameters() []*Identifier {
 
 	p.nextToken()
 
-	ident := &Identifier{Value: p.currentToken.Value}
+	ident := &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
 	identifiers = append(identifiers, ident)
 
 	for p.peekToken.Type == COMMA {
 		p.nextToken()
 		p.nextToken()
-		ident := &Identifier{Value: p.currentToken.Value}
+		ident := &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
 		identifiers = append(identifiers, ident)
 	}
 
-	if !p.expectPeek(RPAREN) {
-		return nil
+	if !p.expectPeek(RPAREN, COMMA) {
+		return identifiers
 	}
 
 	return identifiers
 }
 
+func (p *Parser) parseGarageStatement() *GarageStatement {
+	stmt := &GarageStatement{Token: p.currentToken}
+
+	if !p.expectPeek(IDENTIFIER) {
+		return nil
+	}
+
+	stmt.Name = &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+
+	stmt.Fields = p.parseGarageFields(stmt.Name.Value)
+	if stmt.Fields == nil {
+		return nil
+	}
+
+	return stmt
+}
+
+// parseGarageFields reads a comma separated field list up to the closing
+// brace. Fields may be spread over several lines.
+func (p *Parser) parseGarageFields(garage string) []*Identifier {
+	fields := []*Identifier{}
+	seen := map[string]bool{}
+
+	p.skipPeekNewlines()
+	for p.peekToken.Type != RBRACE {
+		if !p.expectPeek(IDENTIFIER) {
+			return nil
+		}
+
+		name := p.currentToken.Value
+		if seen[name] {
+			p.addError(p.currentToken, nil, "duplicate field %s in garage %s", name, garage)
+			return nil
+		}
+		seen[name] = true
+		fields = append(fields, &Identifier{Token: p.currentToken, Value: name})
+
+		p.skipPeekNewlines()
+		if p.peekToken.Type != COMMA {
+			break
+		}
+		p.nextToken()
+		p.skipPeekNewlines()
+	}
+
+	if !p.expectPeek(RBRACE, COMMA) {
+		return nil
+	}
+
+	return fields
+}
+
+func (p *Parser) parseImportStatement() *ImportStatement {
+	stmt := &ImportStatement{Token: p.currentToken}
+
+	if !p.expectPeek(STRING) {
+		return nil
+	}
+
+	stmt.Path = p.currentToken.Value
+
+	if p.peekToken.Type == AS {
+		p.nextToken()
+		if !p.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		stmt.Alias = &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
+	} else {
+		// Without `as`, the module is bound to its file name.
+		name := strings.TrimSuffix(filepath.Base(stmt.Path), filepath.Ext(stmt.Path))
+		if !isIdentifier(name) {
+			p.addError(p.currentToken, nil, "cannot use %q as a module name, add `as <name>`", name)
+			return nil
+		}
+		stmt.Alias = &Identifier{Token: stmt.Token, Value: name}
+	}
+
+	if p.peekToken.Type == SEMICOLON {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseCircuitStatement() *CircuitStatement {
-	stmt := &CircuitStatement{}
+	stmt := &CircuitStatement{Token: p.currentToken}
 
 	if !p.expectPeek(LPAREN) {
 		return nil
@@ -204,41 +510,202 @@ func (p *Parser) parseCircuitStatement() *CircuitStatement {
 
 	stmt.Consequence = p.parseBlockStatement()
 
-	if p.peekToken.Type == ELSE_CIRCUIT {
+	for p.peekToken.Type == ELSE_CIRCUIT {
 		p.nextToken()
 
-		if !p.expectPeek(LBRACE) {
+		if p.peekToken.Type == CIRCUIT {
+			p.nextToken()
+			branch := &ElseCircuit{Token: p.currentToken}
+
+			if !p.expectPeek(LPAREN) {
+				return nil
+			}
+
+			p.nextToken()
+			branch.Condition = p.parseExpression(LOWEST)
+
+			if !p.expectPeek(RPAREN) {
+				return nil
+			}
+
+			if !p.expectPeek(LBRACE) {
+				return nil
+			}
+
+			branch.Consequence = p.parseBlockStatement()
+			stmt.ElseCircuits = append(stmt.ElseCircuits, branch)
+			continue
+		}
+
+		if !p.expectPeek(LBRACE, CIRCUIT) {
 			return nil
 		}
 
 		stmt.Alternative = p.parseBlockStatement()
+		break
 	}
 
 	return stmt
 }
 
-func (p *Parser) parseLoopStatement() *LoopStatement {
-	stmt := &LoopStatement{}
+// parseStrategyStatement parses `strategy (value) { case ...: ... }`. Each
+// case runs up to the next case, default or the closing brace.
+func (p *Parser) parseStrategyStatement() Statement {
+	stmt := &StrategyStatement{Token: p.currentToken}
 
 	if !p.expectPeek(LPAREN) {
 		return nil
 	}
 
 	p.nextToken()
-	stmt.Init = p.parseStatement()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+	p.nextToken()
+
+	for p.currentToken.Type != RBRACE {
+		switch p.currentToken.Type {
+		case NEWLINE:
+			p.nextToken()
+			continue
+		case CASE:
+			c := &StrategyCase{Token: p.currentToken}
+			p.nextToken()
+			c.Values = append(c.Values, p.parseRangeOrExpression())
+			for p.peekToken.Type == COMMA {
+				p.nextToken()
+				p.nextToken()
+				c.Values = append(c.Values, p.parseRangeOrExpression())
+			}
+			if !p.expectPeek(COLON, COMMA) {
+				return nil
+			}
+			c.Body = p.parseCaseBody()
+			stmt.Cases = append(stmt.Cases, c)
+		case DEFAULT:
+			if stmt.Default != nil {
+				p.addError(p.currentToken, nil, "multiple default cases in strategy")
+				return nil
+			}
+			if !p.expectPeek(COLON) {
+				return nil
+			}
+			stmt.Default = p.parseCaseBody()
+		default:
+			p.addError(p.currentToken, []TokenType{CASE, DEFAULT, RBRACE},
+				"expected CASE or DEFAULT, got %s instead", p.currentToken.Type)
+			return nil
+		}
+		if p.recovering {
+			return nil
+		}
+	}
+
+	return stmt
+}
+
+// parseCaseBody parses the statements after the colon of a case, leaving
+// the case, default or closing brace that ends them as the current token.
+func (p *Parser) parseCaseBody() *BlockStatement {
+	block := &BlockStatement{Token: p.currentToken}
+	block.Statements = []Statement{}
+
+	p.nextToken()
+
+	for {
+		switch p.currentToken.Type {
+		case CASE, DEFAULT, RBRACE, EOF:
+			return block
+		case NEWLINE:
+			p.nextToken()
+			continue
+		}
 
-	if p.currentToken.Type == SEMICOLON {
+		block.Statements = append(block.Statements, p.parseStatementOrSkip())
+		if p.currentToken.Type == RBRACE && p.currentToken == p.failedAt {
+			return block // the closing brace came where an expression was due
+		}
 		p.nextToken()
 	}
+}
+
+// parseLoopStatement parses a three-clause loop, any clause of which may
+// be left empty, or a loop over the elements of a value.
+func (p *Parser) parseLoopStatement(label *Identifier) Statement {
+	stmt := &LoopStatement{Token: p.currentToken, Label: label}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
 
-	stmt.Condition = p.parseExpression(LOWEST)
+	p.nextToken()
+	if p.currentToken.Type == IDENTIFIER && (p.peekToken.Type == IN || p.peekToken.Type == COMMA) {
+		return p.parseLoopInStatement(stmt.Token, label)
+	}
+
+	// Statements swallow the semicolon that ends them.
+	if p.currentToken.Type != SEMICOLON {
+		stmt.Init = p.parseStatement()
+		if p.currentToken.Type != SEMICOLON && !p.expectPeek(SEMICOLON) {
+			return nil
+		}
+	}
+
+	if p.peekToken.Type != SEMICOLON {
+		p.nextToken()
+		stmt.Condition = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(SEMICOLON) {
 		return nil
 	}
 
+	if p.peekToken.Type != RPAREN {
+		p.nextToken()
+		stmt.Update = p.parseExpressionStatement()
+	}
+
+	if !p.expectPeek(RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody(label)
+
+	return stmt
+}
+
+// parseLoopInStatement parses the rest of `loop (x in xs)` or
+// `loop (i, x in xs)` from the first name. The iterable may be a range,
+// start..end.
+func (p *Parser) parseLoopInStatement(token Token, label *Identifier) Statement {
+	stmt := &LoopInStatement{Token: token, Label: label}
+	stmt.Value = &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
+
+	if p.peekToken.Type == COMMA {
+		p.nextToken()
+		if !p.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
+	}
+
+	if !p.expectPeek(IN) {
+		return nil
+	}
+
 	p.nextToken()
-	stmt.Update = p.parseExpressionStatement()
+	stmt.Iterable = p.parseRangeOrExpression()
 
 	if !p.expectPeek(RPAREN) {
 		return nil
@@ -248,13 +715,28 @@ func (p *Parser) parseLoopStatement() *LoopStatement {
 		return nil
 	}
 
-	stmt.Body = p.parseBlockStatement()
+	stmt.Body = p.parseLoopBody(label)
 
 	return stmt
 }
 
-func (p *Parser) parseWhileRacingStatement() *WhileRacingStatement {
-	stmt := &WhileRacingStatement{}
+// parseRangeOrExpression parses an expression, or a range `start..end`
+// where one can appear.
+func (p *Parser) parseRangeOrExpression() Expression {
+	exp := p.parseExpression(LOWEST)
+	if p.peekToken.Type != RANGE {
+		return exp
+	}
+
+	p.nextToken()
+	rng := &RangeExpression{Token: p.currentToken, Start: exp}
+	p.nextToken()
+	rng.End = p.parseExpression(LOWEST)
+	return rng
+}
+
+func (p *Parser) parseWhileRacingStatement(label *Identifier) Statement {
+	stmt := &WhileRacingStatement{Token: p.currentToken, Label: label}
 
 	if !p.expectPeek(LPAREN) {
 		return nil
@@ -271,13 +753,13 @@ func (p *Parser) parseWhileRacingStatement() *WhileRacingStatement {
 		return nil
 	}
 
-	stmt.Body = p.parseBlockStatement()
+	stmt.Body = p.parseLoopBody(label)
 
 	return stmt
 }
 
 func (p *Parser) parseReturnPitStatement() *ReturnPitStatement {
-	stmt := &ReturnPitStatement{}
+	stmt := &ReturnPitStatement{Token: p.currentToken}
 
 	if p.peekToken.Type != SEMICOLON && p.peekToken.Type != NEWLINE {
 		p.nextToken()
@@ -291,44 +773,91 @@ func (p *Parser) parseReturnPitStatement() *ReturnPitStatement {
 	return stmt
 }
 
-func (p *Parser) parseBreakFlagStatement() *BreakFlagStatement {
+func (p *Parser) parseBreakFlagStatement() Statement {
+	stmt := &BreakFlagStatement{Token: p.currentToken, Label: p.parseJumpLabel()}
+	if !p.checkJump(stmt.Token, stmt.Label) {
+		return nil
+	}
 	if p.peekToken.Type == SEMICOLON {
 		p.nextToken()
 	}
-	return &BreakFlagStatement{}
+	return stmt
 }
 
-func (p *Parser) parseContinueRaceStatement() *ContinueRaceStatement {
+func (p *Parser) parseContinueRaceStatement() Statement {
+	stmt := &ContinueRaceStatement{Token: p.currentToken, Label: p.parseJumpLabel()}
+	if !p.checkJump(stmt.Token, stmt.Label) {
+		return nil
+	}
 	if p.peekToken.Type == SEMICOLON {
 		p.nextToken()
 	}
-	return &ContinueRaceStatement{}
+	return stmt
 }
 
-func (p *Parser) parseBlockStatement() *BlockStatement {
-	block := &BlockStatement{}
-	block.Statements = []Statement{}
-
+// parseJumpLabel reads the label after break_flag or continue_race, if
+// there is one on the same line.
+func (p *Parser) parseJumpLabel() *Identifier {
+	if p.peekToken.Type != IDENTIFIER {
+		return nil
+	}
 	p.nextToken()
+	return &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
+}
 
-	for p.currentToken.Type != RBRACE && p.currentToken.Type != EOF {
-		if p.currentToken.Type == NEWLINE {
-			p.nextToken()
-			continue
+// checkJump reports a break_flag or continue_race at tok with no loop
+// around it, or naming a label that none of its loops have.
+func (p *Parser) checkJump(tok Token, label *Identifier) bool {
+	if len(p.loops) == 0 {
+		p.addError(tok, nil, "%s outside of a loop", tok.Value)
+		return false
+	}
+	if label == nil {
+		return true
+	}
+	for _, name := range p.loops {
+		if name == label.Value {
+			return true
+		}
+	}
+	p.addError(label.Token, nil, "no enclosing loop is labelled %s", label.Value)
+	return false
+}
+
+func (p *Parser) parseBlockStatement() *BlockStatement {
+	block := &BlockStatement{Token: p.currentToken}
+	block.Statements = []Statement{}
+
+	p.nextToken()
+
+	for p.currentToken.Type != RBRACE && p.currentToken.Type != EOF {
+		if p.currentToken.Type == NEWLINE {
+			p.nextToken()
+			continue
 		}
 
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
+		block.Statements = append(block.Statements, p.parseStatementOrSkip())
+		if p.currentToken.Type == RBRACE && p.currentToken == p.failedAt {
+			break // the closing brace came where an expression was due
 		}
 		p.nextToken()
 	}
 
+	// An unclosed block is reported at its opening brace, and only once for
+	// all the blocks the end of the file leaves open.
+	if p.currentToken.Type == EOF && p.currentToken != p.failedAt {
+		p.report(p.currentToken, &SyntaxError{
+			Message:  fmt.Sprintf("expected %s, got %s", RBRACE, EOF),
+			Pos:      block.Token.Pos(),
+			Expected: []TokenType{RBRACE},
+		})
+	}
+
 	return block
 }
 
 func (p *Parser) parseExpressionStatement() *ExpressionStatement {
-	stmt := &ExpressionStatement{}
+	stmt := &ExpressionStatement{Token: p.currentToken}
 	stmt.Expression = p.parseExpression(LOWEST)
 
 	if p.peekToken.Type == SEMICOLON {
@@ -338,7 +867,18 @@ func (p *Parser) parseExpressionStatement() *ExpressionStatement {
 	return stmt
 }
 
+// parseExpression never returns nil: an expression that does not parse is
+// a BadExpression.
 func (p *Parser) parseExpression(precedence PrecedenceLevel) Expression {
+	start := p.currentToken
+	leftExp := p.parseOperand(precedence)
+	if leftExp == nil {
+		return &BadExpression{Token: start}
+	}
+	return leftExp
+}
+
+func (p *Parser) parseOperand(precedence PrecedenceLevel) Expression {
 	var leftExp Expression
 
 	switch p.currentToken.Type {
@@ -348,20 +888,33 @@ func (p *Parser) parseExpression(precedence PrecedenceLevel) Expression {
 		leftExp = p.parseNumberLiteral()
 	case STRING:
 		leftExp = p.parseStringLiteral()
+	case TEMPLATE:
+		leftExp = p.parseInterpolatedString()
 	case BOOLEAN:
 		leftExp = p.parseBooleanLiteral()
 	case LBRACKET:
 		leftExp = p.parseFormationLiteral()
+	case LBRACE:
+		leftExp = p.parseMapLiteral()
 	case MINUS, NOT:
 		leftExp = p.parsePrefixExpression()
 	case LPAREN:
 		leftExp = p.parseGroupedExpression()
+	case PACE:
+		leftExp = p.parsePaceLiteral()
+	case MATCH:
+		leftExp = p.parseMatchExpression()
 	default:
+		if p.currentToken.Type == ILLEGAL && utf8.RuneCountInString(p.currentToken.Value) > 1 {
+			// the lexer's description of a malformed literal
+			p.addError(p.currentToken, nil, "%s", p.currentToken.Value)
+			return nil
+		}
 		p.noPrefixParseFnError(p.currentToken.Type)
 		return nil
 	}
 
-	for p.peekToken.Type != SEMICOLON && precedence < p.peekPrecedence() {
+	for !p.recovering && p.peekToken.Type != SEMICOLON && precedence < p.peekPrecedence() {
 		switch p.peekToken.Type {
 		case PLUS, MINUS, DIVIDE, MULTIPLY, MODULO, EQUAL, NOT_EQUAL, LESS, GREATER, LESS_EQUAL, GREATER_EQUAL, AND, OR:
 			p.nextToken()
@@ -372,9 +925,15 @@ func (p *Parser) parseExpression(precedence PrecedenceLevel) Expression {
 		case LBRACKET:
 			p.nextToken()
 			leftExp = p.parseIndexExpression(leftExp)
+		case DOT:
+			p.nextToken()
+			leftExp = p.parseMemberExpression(leftExp)
 		case ASSIGN:
 			p.nextToken()
 			leftExp = p.parseAssignmentExpression(leftExp)
+		case PLUS_ASSIGN, MINUS_ASSIGN, MULTIPLY_ASSIGN, DIVIDE_ASSIGN, MODULO_ASSIGN, INCREMENT, DECREMENT:
+			p.nextToken()
+			leftExp = p.parseCompoundAssignmentExpression(leftExp)
 		default:
 			return leftExp
 		}
@@ -384,37 +943,414 @@ func (p *Parser) parseExpression(precedence PrecedenceLevel) Expression {
 }
 
 func (p *Parser) parseIdentifier() Expression {
-	return &Identifier{Value: p.currentToken.Value}
+	return &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
 }
 
+// parseNumberLiteral makes an IntegerLiteral of a literal with no fraction
+// or exponent and a NumberLiteral of the rest. The lexer has already
+// checked the literal's syntax.
 func (p *Parser) parseNumberLiteral() Expression {
-	lit := &NumberLiteral{}
+	text := strings.ReplaceAll(p.currentToken.Value, "_", "")
+
+	if base := numberBase(text); base != 10 || !strings.ContainsAny(text, ".eE") {
+		if base != 10 {
+			text = text[2:]
+		}
+		value, err := strconv.ParseInt(text, base, 64)
+		if err != nil {
+			p.addError(p.currentToken, nil, "integer literal %s is out of range", p.currentToken.Value)
+			return nil
+		}
+		return &IntegerLiteral{Token: p.currentToken, Value: value}
+	}
 
-	value, err := strconv.ParseFloat(p.currentToken.Value, 64)
+	value, err := strconv.ParseFloat(text, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as number", p.currentToken.Value)
-		p.errors = append(p.errors, msg)
+		p.addError(p.currentToken, nil, "number literal %s is out of range", p.currentToken.Value)
 		return nil
 	}
-
-	lit.Value = value
-	return lit
+	return &NumberLiteral{Token: p.currentToken, Value: value}
 }
 
 func (p *Parser) parseStringLiteral() Expression {
-	return &StringLiteral{Value: p.currentToken.Value}
+	return &StringLiteral{Token: p.currentToken, Value: p.currentToken.Value}
+}
+
+// parseInterpolatedString splits a TEMPLATE token into its text and the
+// expressions inside ${...}, each parsed from its own place in the source.
+func (p *Parser) parseInterpolatedString() Expression {
+	node := &InterpolatedString{Token: p.currentToken}
+
+	lexer := &Lexer{input: "\"" + node.Token.Value + "\"", line: node.Token.Line, column: node.Token.Column}
+	parts, _ := lexer.scanString()
+
+	for _, part := range parts {
+		if !part.expr {
+			text := Token{Type: STRING, Value: part.text, Line: node.Token.Line, Column: node.Token.Column}
+			node.Parts = append(node.Parts, &StringLiteral{Token: text, Value: part.text})
+			continue
+		}
+
+		inner := NewParser(&Lexer{input: part.text, line: part.pos.Line, column: part.pos.Column})
+		for inner.currentToken.Type == NEWLINE {
+			inner.nextToken()
+		}
+		if inner.currentToken.Type == EOF {
+			p.report(node.Token, &SyntaxError{Message: "empty expression in string interpolation", Pos: part.pos})
+			return nil
+		}
+
+		expr := inner.parseExpression(LOWEST)
+		inner.skipPeekNewlines()
+		if inner.peekToken.Type != EOF {
+			inner.addError(inner.peekToken, nil, "unexpected %s in string interpolation", inner.peekToken.Type)
+		}
+		if len(inner.errors) > 0 {
+			p.report(node.Token, inner.errors[0])
+			return nil
+		}
+
+		node.Parts = append(node.Parts, expr)
+	}
+
+	return node
 }
 
 func (p *Parser) parseBooleanLiteral() Expression {
-	return &BooleanLiteral{Value: p.currentToken.Value == "true"}
+	return &BooleanLiteral{Token: p.currentToken, Value: p.currentToken.Value == "true"}
 }
 
 func (p *Parser) parseFormationLiteral() Expression {
-	lit := &FormationLiteral{}
+	lit := &FormationLiteral{Token: p.currentToken}
 	lit.Elements = p.parseExpressionList(RBRACKET)
 	return lit
 }
 
+func (p *Parser) parseMapLiteral() Expression {
+	lit := &MapLiteral{Token: p.currentToken, Pairs: []MapPair{}}
+
+	p.skipPeekNewlines()
+	for p.peekToken.Type != RBRACE {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+
+		if lit.Token == p.armBody && len(lit.Pairs) == 0 && p.peekToken.Type != COLON && !p.recovering {
+			p.addError(lit.Token, nil, "a match arm body is an expression, not a block")
+			return nil
+		}
+		if !p.expectPeek(COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+		lit.Pairs = append(lit.Pairs, MapPair{Key: key, Value: value})
+
+		p.skipPeekNewlines()
+		if p.peekToken.Type != COMMA {
+			break
+		}
+		p.nextToken()
+		p.skipPeekNewlines()
+	}
+
+	if !p.expectPeek(RBRACE, COMMA) {
+		return nil
+	}
+
+	return lit
+}
+
+// parseMatchExpression parses `match (value) { pattern => body, ... }`.
+// Arms are separated by commas or newlines.
+func (p *Parser) parseMatchExpression() Expression {
+	exp := &MatchExpression{Token: p.currentToken}
+
+	if !p.expectPeek(LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	exp.Value = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(LBRACE) {
+		return nil
+	}
+
+	p.skipPeekNewlines()
+	for p.peekToken.Type != RBRACE {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		exp.Arms = append(exp.Arms, arm)
+
+		switch p.peekToken.Type {
+		case COMMA:
+			p.nextToken()
+		case NEWLINE, RBRACE:
+		default:
+			p.peekError(COMMA, NEWLINE, RBRACE)
+			return nil
+		}
+		p.skipPeekNewlines()
+	}
+	p.nextToken()
+
+	if len(exp.Arms) == 0 {
+		p.addError(exp.Token, nil, "match has no arms")
+		return nil
+	}
+
+	return exp
+}
+
+func (p *Parser) parseMatchArm() *MatchArm {
+	arm := &MatchArm{Pattern: p.parsePattern(map[string]bool{})}
+	if arm.Pattern == nil {
+		return nil
+	}
+
+	if p.peekToken.Type == CIRCUIT {
+		p.nextToken()
+
+		if !p.expectPeek(LPAREN) {
+			return nil
+		}
+
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+
+		if !p.expectPeek(RPAREN) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(ARROW) {
+		return nil
+	}
+
+	// A brace here opens a map literal; one that turns out to hold
+	// statements gets an error of its own.
+	p.nextToken()
+	p.armBody = p.currentToken
+	arm.Body = p.parseExpression(LOWEST)
+
+	return arm
+}
+
+// parsePattern parses the pattern at the current token. bound holds the
+// names bound so far in the arm, which may each be bound only once.
+func (p *Parser) parsePattern(bound map[string]bool) Pattern {
+	switch p.currentToken.Type {
+	case IDENTIFIER:
+		if p.peekToken.Type == LBRACE {
+			return p.parseStructPattern(bound)
+		}
+		return p.parseNamePattern(bound)
+	case NUMBER, STRING, BOOLEAN, MINUS:
+		lit := p.parsePatternLiteral()
+		if lit == nil {
+			return nil
+		}
+		if p.peekToken.Type != RANGE {
+			return &LiteralPattern{Value: lit}
+		}
+
+		p.nextToken()
+		rng := &RangePattern{Token: p.currentToken, Start: lit}
+		p.nextToken()
+		if rng.End = p.parsePatternLiteral(); rng.End == nil {
+			return nil
+		}
+		for _, limit := range []Expression{rng.Start, rng.End} {
+			switch limit.(type) {
+			case *IntegerLiteral, *NumberLiteral:
+			default:
+				p.addError(rng.Token, nil, "range pattern bounds must be numbers")
+				return nil
+			}
+		}
+		return rng
+	case LBRACKET:
+		return p.parseArrayPattern(bound)
+	case LBRACE:
+		return p.parseMapPattern(bound)
+	default:
+		p.addError(p.currentToken, nil, "unexpected %s in pattern", p.currentToken.Type)
+		return nil
+	}
+}
+
+// parseNamePattern parses the identifier at the current token as `_` or a
+// name to bind.
+func (p *Parser) parseNamePattern(bound map[string]bool) Pattern {
+	name := &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
+	if name.Value == "_" {
+		return &WildcardPattern{Token: p.currentToken}
+	}
+	if bound[name.Value] {
+		p.addError(name.Token, nil, "%s is bound more than once in the pattern", name.Value)
+		return nil
+	}
+	bound[name.Value] = true
+	return &BindingPattern{Name: name}
+}
+
+// parsePatternLiteral parses a number, string or boolean literal, or a
+// negative number.
+func (p *Parser) parsePatternLiteral() Expression {
+	switch p.currentToken.Type {
+	case NUMBER:
+		return p.parseNumberLiteral()
+	case STRING:
+		return p.parseStringLiteral()
+	case BOOLEAN:
+		return p.parseBooleanLiteral()
+	case MINUS:
+		minus := p.currentToken
+		if !p.expectPeek(NUMBER) {
+			return nil
+		}
+		switch lit := p.parseNumberLiteral().(type) {
+		case *IntegerLiteral:
+			return &IntegerLiteral{Token: minus, Value: -lit.Value}
+		case *NumberLiteral:
+			return &NumberLiteral{Token: minus, Value: -lit.Value}
+		}
+		return nil
+	default:
+		p.addError(p.currentToken, nil, "expected a literal in pattern, got %s instead", p.currentToken.Type)
+		return nil
+	}
+}
+
+func (p *Parser) parseArrayPattern(bound map[string]bool) Pattern {
+	pattern := &ArrayPattern{Token: p.currentToken}
+
+	p.skipPeekNewlines()
+	for p.peekToken.Type != RBRACKET {
+		p.nextToken()
+		if p.currentToken.Type == ELLIPSIS {
+			if !p.expectPeek(IDENTIFIER) {
+				return nil
+			}
+			if pattern.Rest = p.parseNamePattern(bound); pattern.Rest == nil {
+				return nil
+			}
+			p.skipPeekNewlines()
+			if !p.expectPeek(RBRACKET) {
+				return nil
+			}
+			return pattern
+		}
+
+		element := p.parsePattern(bound)
+		if element == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+
+		p.skipPeekNewlines()
+		if p.peekToken.Type != COMMA {
+			break
+		}
+		p.nextToken()
+		p.skipPeekNewlines()
+	}
+
+	if !p.expectPeek(RBRACKET, COMMA) {
+		return nil
+	}
+
+	return pattern
+}
+
+func (p *Parser) parseMapPattern(bound map[string]bool) Pattern {
+	pattern := &MapPattern{Token: p.currentToken}
+
+	p.skipPeekNewlines()
+	for p.peekToken.Type != RBRACE {
+		p.nextToken()
+		key := p.parsePatternLiteral()
+		if key == nil {
+			return nil
+		}
+
+		if !p.expectPeek(COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		value := p.parsePattern(bound)
+		if value == nil {
+			return nil
+		}
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Values = append(pattern.Values, value)
+
+		p.skipPeekNewlines()
+		if p.peekToken.Type != COMMA {
+			break
+		}
+		p.nextToken()
+		p.skipPeekNewlines()
+	}
+
+	if !p.expectPeek(RBRACE, COMMA) {
+		return nil
+	}
+
+	return pattern
+}
+
+// parseStructPattern parses `Garage { field: pattern, ... }`, where a
+// field on its own binds the field's value to its name.
+func (p *Parser) parseStructPattern(bound map[string]bool) Pattern {
+	pattern := &StructPattern{Garage: &Identifier{Token: p.currentToken, Value: p.currentToken.Value}}
+	p.nextToken()
+
+	p.skipPeekNewlines()
+	for p.peekToken.Type != RBRACE {
+		if !p.expectPeek(IDENTIFIER) {
+			return nil
+		}
+		field := &Identifier{Token: p.currentToken, Value: p.currentToken.Value}
+
+		var value Pattern
+		if p.peekToken.Type == COLON {
+			p.nextToken()
+			p.nextToken()
+			value = p.parsePattern(bound)
+		} else {
+			value = p.parseNamePattern(bound)
+		}
+		if value == nil {
+			return nil
+		}
+		pattern.Fields = append(pattern.Fields, field)
+		pattern.Values = append(pattern.Values, value)
+
+		p.skipPeekNewlines()
+		if p.peekToken.Type != COMMA {
+			break
+		}
+		p.nextToken()
+		p.skipPeekNewlines()
+	}
+
+	if !p.expectPeek(RBRACE, COMMA) {
+		return nil
+	}
+
+	return pattern
+}
+
 func (p *Parser) parseExpressionList(end TokenType) []Expression {
 	args := []Expression{}
 
@@ -432,15 +1368,13 @@ func (p *Parser) parseExpressionList(end TokenType) []Expression {
 		args = append(args, p.parseExpression(LOWEST))
 	}
 
-	if !p.expectPeek(end) {
-		return nil
-	}
-
+	p.expectPeek(end, COMMA)
 	return args
 }
 
 func (p *Parser) parsePrefixExpression() Expression {
 	expression := &PrefixExpression{
+		Token:    p.currentToken,
 		Operator: p.currentToken.Value,
 	}
 
@@ -452,6 +1386,7 @@ func (p *Parser) parsePrefixExpression() Expression {
 
 func (p *Parser) parseInfixExpression(left Expression) Expression {
 	expression := &InfixExpression{
+		Token:    p.currentToken,
 		Left:     left,
 		Operator: p.currentToken.Value,
 	}
@@ -476,36 +1411,107 @@ func (p *Parser) parseGroupedExpression() Expression {
 }
 
 func (p *Parser) parseCallExpression(fn Expression) Expression {
-	exp := &CallExpression{Function: fn}
+	exp := &CallExpression{Token: p.currentToken, Function: fn}
 	exp.Arguments = p.parseExpressionList(RPAREN)
 	return exp
 }
 
+// parseIndexExpression parses left[index], or the slice left[start:end]
+// in which either bound may be left out.
 func (p *Parser) parseIndexExpression(left Expression) Expression {
-	exp := &IndexExpression{Left: left}
+	bracket := p.currentToken
 
-	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	var index Expression
+	if p.peekToken.Type != COLON {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
+	}
+
+	if p.peekToken.Type == COLON {
+		p.nextToken()
+		exp := &SliceExpression{Token: bracket, Left: left, Start: index}
+		if p.peekToken.Type != RBRACKET {
+			p.nextToken()
+			exp.End = p.parseExpression(LOWEST)
+		}
+		if !p.expectPeek(RBRACKET) {
+			return nil
+		}
+		return exp
+	}
 
 	if !p.expectPeek(RBRACKET) {
 		return nil
 	}
 
-	return exp
+	return &IndexExpression{Token: bracket, Left: left, Index: index}
+}
+
+func (p *Parser) parseMemberExpression(object Expression) Expression {
+	dot := p.currentToken
+
+	if !p.expectPeek(IDENTIFIER) {
+		return nil
+	}
+
+	return &MemberExpression{
+		Token:    dot,
+		Object:   object,
+		Property: &Identifier{Token: p.currentToken, Value: p.currentToken.Value},
+	}
 }
 
 func (p *Parser) parseAssignmentExpression(left Expression) Expression {
-	ident, ok := left.(*Identifier)
-	if !ok {
-		p.errors = append(p.errors, "invalid assignment target")
+	switch target := left.(type) {
+	case *Identifier:
+		exp := &AssignmentExpression{Token: p.currentToken, Name: target}
+		p.nextToken()
+		exp.Value = p.parseExpression(LOWEST)
+		nameFunction(exp.Value, target.Value)
+		return exp
+	case *MemberExpression:
+		exp := &FieldAssignmentExpression{Token: p.currentToken, Target: target}
+		p.nextToken()
+		exp.Value = p.parseExpression(LOWEST)
+		return exp
+	case *IndexExpression:
+		exp := &IndexAssignmentExpression{Token: p.currentToken, Target: target}
+		p.nextToken()
+		exp.Value = p.parseExpression(LOWEST)
+		return exp
+	default:
+		p.addError(p.currentToken, nil, "invalid assignment target")
 		return nil
 	}
+}
 
-	exp := &AssignmentExpression{Name: ident}
+// compoundOperators maps each compound assignment token to the arithmetic
+// it applies.
+var compoundOperators = map[TokenType]string{
+	PLUS_ASSIGN: "+", MINUS_ASSIGN: "-", MULTIPLY_ASSIGN: "*", DIVIDE_ASSIGN: "/", MODULO_ASSIGN: "%",
+	INCREMENT: "+", DECREMENT: "-",
+}
+
+func (p *Parser) parseCompoundAssignmentExpression(left Expression) Expression {
+	switch left.(type) {
+	case *Identifier, *IndexExpression, *MemberExpression:
+	default:
+		p.addError(p.currentToken, nil, "invalid assignment target")
+		return nil
+	}
+
+	exp := &CompoundAssignmentExpression{
+		Token:    p.currentToken,
+		Target:   left,
+		Operator: compoundOperators[p.currentToken.Type],
+	}
+	if p.currentToken.Type == INCREMENT || p.currentToken.Type == DECREMENT {
+		exp.Value = &IntegerLiteral{Token: p.currentToken, Value: 1}
+		return exp
+	}
 
 	p.nextToken()
 	exp.Value = p.parseExpression(LOWEST)
-
 	return exp
 }
 
@@ -523,27 +1529,67 @@ func (p *Parser) peekPrecedence() PrecedenceLevel {
 	return LOWEST
 }
 
-func (p *Parser) expectPeek(t TokenType) bool {
+func (p *Parser) skipPeekNewlines() {
+	for p.peekToken.Type == NEWLINE {
+		p.nextToken()
+	}
+}
+
+// expectPeek moves on to the next token if it is a t. Otherwise it
+// reports an error naming t and any alternatives that would also have
+// been accepted there. Once the statement has an error, it always fails,
+// so the statement is abandoned where the error was found.
+func (p *Parser) expectPeek(t TokenType, alternatives ...TokenType) bool {
+	if p.recovering {
+		return false
+	}
 	if p.peekToken.Type == t {
 		p.nextToken()
 		return true
-	} else {
-		p.peekError(t)
-		return false
 	}
+	p.peekError(append(alternatives, t)...)
+	return false
 }
 
-func (p *Parser) peekError(t TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+func (p *Parser) peekError(expected ...TokenType) {
+	names := make([]string, len(expected))
+	for idx, t := range expected {
+		names[idx] = t.String()
+	}
+	p.addError(p.peekToken, expected, "expected next token to be %s, got %s instead",
+		strings.Join(names, " or "), p.peekToken.Type)
 }
 
 func (p *Parser) noPrefixParseFnError(t TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.currentToken, nil, "no prefix parse function for %s found", t)
 }
 
+// addError reports a syntax error found at tok.
+func (p *Parser) addError(tok Token, expected []TokenType, format string, a ...interface{}) {
+	p.report(tok, &SyntaxError{Message: fmt.Sprintf(format, a...), Pos: tok.Pos(), Expected: expected})
+}
+
+// report records err, found at tok, unless the statement already has an
+// error: later ones are nearly always caused by the first.
+func (p *Parser) report(tok Token, err *SyntaxError) {
+	if p.recovering {
+		return
+	}
+	p.recovering = true
+	p.failedAt = tok
+	p.errors = append(p.errors, err)
+}
+
+// Errors returns the message of each syntax error, in source order.
 func (p *Parser) Errors() []string {
+	messages := make([]string, len(p.errors))
+	for idx, err := range p.errors {
+		messages[idx] = err.Message
+	}
+	return messages
+}
+
+// Diagnostics returns each syntax error with its position.
+func (p *Parser) Diagnostics() []*SyntaxError {
 	return p.errors
 }
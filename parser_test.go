@@ -0,0 +1,197 @@
+package alonso
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProgramErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"grid x 5", []string{"expected next token to be ASSIGN, got NUMBER instead"}},
+		{"grid x = ", []string{"no prefix parse function for EOF found"}},
+		{"grid = 5", []string{"expected next token to be IDENTIFIER, got ASSIGN instead"}},
+		{"pace f(x {", []string{"expected next token to be COMMA or RPAREN, got LBRACE instead"}},
+		{"pace f(x) x", []string{"expected next token to be LBRACE, got IDENTIFIER instead"}},
+		{"circuit x { }", []string{"expected next token to be LPAREN, got IDENTIFIER instead"}},
+		{"circuit (x) x", []string{"expected next token to be LBRACE, got IDENTIFIER instead"}},
+		{"while_racing x {}", []string{"expected next token to be LPAREN, got IDENTIFIER instead"}},
+		{"loop (grid i = 0; i < 3) { }", []string{"expected next token to be SEMICOLON, got RPAREN instead"}},
+		{"loop (grid i = 0 i < 3; i++) { }", []string{"expected next token to be SEMICOLON, got IDENTIFIER instead"}},
+		{"loop (i, 2 in xs) { }", []string{"expected next token to be IDENTIFIER, got NUMBER instead"}},
+		{"loop (i, x of xs) { }", []string{"expected next token to be IN, got IDENTIFIER instead"}},
+		{"loop (lap in 1..) { }", []string{"no prefix parse function for RPAREN found"}},
+		{"break_flag", []string{"break_flag outside of a loop"}},
+		{"circuit (x) { continue_race }", []string{"continue_race outside of a loop"}},
+		{"loop (;;) { pace f() { break_flag } }", []string{"break_flag outside of a loop"}},
+		{"a: loop (;;) { break_flag b }", []string{"no enclosing loop is labelled b"}},
+		{"a: loop (;;) { a: loop (;;) { } }", []string{"label a is already used by an enclosing loop"}},
+		{"a: circuit (x) { }", []string{"expected next token to be LOOP or WHILE_RACING, got CIRCUIT instead"}},
+		{"circuit (x) { } else_circuit circuit x { }", []string{"expected next token to be LPAREN, got IDENTIFIER instead"}},
+		{"circuit (x) { } else_circuit x", []string{"expected next token to be CIRCUIT or LBRACE, got IDENTIFIER instead"}},
+		{"strategy x { }", []string{"expected next token to be LPAREN, got IDENTIFIER instead"}},
+		{"strategy (x) { 1: y }", []string{"expected CASE or DEFAULT, got NUMBER instead"}},
+		{"strategy (x) { case 1 y }", []string{"expected next token to be COMMA or COLON, got IDENTIFIER instead"}},
+		{"strategy (x) { default: y default: z }", []string{"multiple default cases in strategy"}},
+		{"strategy (x) { case 1: y", []string{"expected CASE or DEFAULT, got EOF instead"}},
+		{"match (x) { }", []string{"match has no arms"}},
+		{"match (x) { 1 2 }", []string{"expected next token to be ARROW, got NUMBER instead"}},
+		{"match (x) { 1 => a 2 => b }", []string{"expected next token to be COMMA or NEWLINE or RBRACE, got NUMBER instead"}},
+		{"match (x) { [a, a] => a }", []string{"a is bound more than once in the pattern"}},
+		{"match (x) { [...r, a] => a }", []string{"expected next token to be RBRACKET, got COMMA instead"}},
+		{"match (x) { \"a\"..\"z\" => 1 }", []string{"range pattern bounds must be numbers"}},
+		{"match (x) { a + 1 => a }", []string{"expected next token to be ARROW, got PLUS instead"}},
+		{"match (x) { (a) => a }", []string{"unexpected LPAREN in pattern"}},
+		{"match (x) { {a: 1} => a }", []string{"expected a literal in pattern, got IDENTIFIER instead"}},
+		{"match (x) { 1 => { telemetry(\"hi\") } }", []string{"a match arm body is an expression, not a block"}},
+		{"match (x) {\n 1 => { y }\n _ => 2\n}", []string{"a match arm body is an expression, not a block"}},
+		{"1 = 2", []string{"invalid assignment target"}},
+		{"f(1, 2", []string{"expected next token to be COMMA or RPAREN, got EOF instead"}},
+		{"[1, 2", []string{"expected next token to be COMMA or RBRACKET, got EOF instead"}},
+		{"x[1", []string{"expected next token to be RBRACKET, got EOF instead"}},
+		{"x.1", []string{"expected next token to be IDENTIFIER, got NUMBER instead"}},
+		{"return_pit )", []string{"no prefix parse function for RPAREN found"}},
+		{"@", []string{"no prefix parse function for ILLEGAL found"}},
+		{"garage { a }", []string{"expected next token to be IDENTIFIER, got LBRACE instead"}},
+		{"garage Car { a, a }", []string{"duplicate field a in garage Car"}},
+		{"import x", []string{"expected next token to be STRING, got IDENTIFIER instead"}},
+		{`import "lib/my-mod.alo"`, []string{"cannot use \"my-mod\" as a module name, add `as <name>`"}},
+		{"safety_car { } 5", []string{"expected next token to be RECOVER, got NUMBER instead"}},
+		{"safety_car { } recover (1) { }", []string{"expected next token to be IDENTIFIER, got NUMBER instead"}},
+		{`"lap \q"`, []string{`invalid escape sequence \q`}},
+		{`"\u{110000}"`, []string{`invalid unicode escape \u{110000}`}},
+		{`"\u0041"`, []string{`invalid unicode escape: expected \u{...}`}},
+		{`"box ${lap"`, []string{"unterminated string"}},
+		{"`box", []string{"unterminated string"}},
+		{`"${}"`, []string{"empty expression in string interpolation"}},
+		{`"${1 2}"`, []string{"unexpected NUMBER in string interpolation"}},
+		{"grid x = 1.2.3", []string{"invalid number literal 1.2.3"}},
+		{"f() += 1", []string{"invalid assignment target"}},
+		{"1++", []string{"invalid assignment target"}},
+		{"9223372036854775808", []string{"integer literal 9223372036854775808 is out of range"}},
+		{`"${grid}"`, []string{"no prefix parse function for GRID found"}},
+		{"circuit (true) { telemetry(1)", []string{"expected RBRACE, got EOF"}},
+		{"pace f() {", []string{"expected RBRACE, got EOF"}},
+		{"circuit (a) {\n circuit (b) {\n", []string{"expected RBRACE, got EOF"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := NewParser(NewLexer(tt.input))
+			p.ParseProgram()
+
+			if got := p.Errors(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("errors = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseProgramRecovery checks that parsing resumes after each mistake,
+// reporting every one once, where it was found.
+func TestParseProgramRecovery(t *testing.T) {
+	input := `grid x 5
+telemetry(x +)
+circuit (x > 1) {
+    grid = 2
+    telemetry("ok")
+    grid y = }
+grid z = [1, 2
+pace f(a {
+    return_pit a
+}
+f(1
+grid w = 3`
+
+	want := []*SyntaxError{
+		{Message: "expected next token to be ASSIGN, got NUMBER instead", Pos: Position{1, 8}, Expected: []TokenType{ASSIGN}},
+		{Message: "no prefix parse function for RPAREN found", Pos: Position{2, 14}},
+		{Message: "expected next token to be IDENTIFIER, got ASSIGN instead", Pos: Position{4, 10}, Expected: []TokenType{IDENTIFIER}},
+		{Message: "no prefix parse function for RBRACE found", Pos: Position{6, 14}},
+		{Message: "expected next token to be COMMA or RBRACKET, got NEWLINE instead", Pos: Position{7, 15}, Expected: []TokenType{COMMA, RBRACKET}},
+		{Message: "expected next token to be COMMA or RPAREN, got LBRACE instead", Pos: Position{8, 10}, Expected: []TokenType{COMMA, RPAREN}},
+		{Message: "expected next token to be COMMA or RPAREN, got NEWLINE instead", Pos: Position{11, 4}, Expected: []TokenType{COMMA, RPAREN}},
+	}
+
+	p := NewParser(NewLexer(input))
+	program := p.ParseProgram()
+
+	if got := p.Diagnostics(); !reflect.DeepEqual(got, want) {
+		for _, err := range got {
+			t.Logf("got %s %v", err, err.Expected)
+		}
+		t.Fatalf("got %d diagnostics, want %d", len(got), len(want))
+	}
+
+	// Statements that did not parse are placeholders, so the whole tree
+	// can still be printed.
+	wantTree := "<bad statement>;<bad statement>;circuit ((x > 1)) {<bad statement>;telemetry(\"ok\");<bad statement>;}" +
+		"<bad statement>;<bad statement>;<bad statement>;grid w = 3;"
+	if got := program.String(); got != wantTree {
+		t.Errorf("String() = %q, want %q", got, wantTree)
+	}
+
+	// A block left open is reported at its opening brace.
+	p = NewParser(NewLexer("grid x = 1\npace f() {\n    return_pit x\n"))
+	p.ParseProgram()
+	unclosed := []*SyntaxError{{Message: "expected RBRACE, got EOF", Pos: Position{2, 10}, Expected: []TokenType{RBRACE}}}
+	if got := p.Diagnostics(); !reflect.DeepEqual(got, unclosed) {
+		t.Errorf("unclosed block: got %v, want %v", got, unclosed)
+	}
+}
+
+func TestParseProgram(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"grid x = 1 + 2 * 3", "grid x = (1 + (2 * 3));"},
+		{"-a * b", "((-a) * b);"},
+		{"!(a == b) && c", "((!(a == b)) && c);"},
+		{"a.b.c(1)[0]", "(((a.b).c)(1)[0]);"},
+		{"car.number = 15", "(car.number) = 15;"},
+		{`grid m = {"ALO": 14}["ALO"]`, `grid m = ({"ALO": 14}["ALO"]);`},
+		{"pace(x) { x * 2 }(21)", "pace(x) {(x * 2);}(21);"},
+		{`import "lib/lap_times.alo" as laps`, `import "lib/lap_times.alo" as laps`},
+		{`"lap ${n + 1} of ${laps["total"]}"`, `"lap ${(n + 1)} of ${(laps["total"])}";`},
+		{`"${"inner ${x}"}"`, `"${"inner ${x}"}";`},
+		{`"\${literal}"`, `"${literal}";`},
+		{"s[1:n - 1]", "(s[1:(n - 1)]);"},
+		{"s[:2][1:]", "((s[:2])[1:]);"},
+		{"s[:]", "(s[:]);"},
+		{"0xFF + 1_000 * 2.5e1", "(255 + (1000 * 25));"},
+		{"lap += 1 + 2", "lap += (1 + 2);"},
+		{"laps[i] *= x = 2", "(laps[i]) *= x = 2;"},
+		{"car.laps++", "(car.laps)++;"},
+		{"a[i]-- + 1", "((a[i])-- + 1);"},
+		{"loop (;;) { }", "loop (; ; ) {}"},
+		{"loop (grid i = 0; ; i++) { }", "loop (grid i = 0;; ; i++;) {}"},
+		{"loop (d in drivers) { d }", "loop (d in drivers) {d;}"},
+		{"loop (i, lap in 1..n + 1) { }", "loop (i, lap in 1..(n + 1)) {}"},
+		{"stint: loop (;;) { break_flag stint }", "stint: loop (; ; ) {break_flag stint;}"},
+		{"race: while_racing (x) { continue_race race }", "race: while_racing (x) {continue_race race;}"},
+		{"field: loop (d in drivers) { continue_race }", "field: loop (d in drivers) {continue_race;}"},
+		{"circuit (a) { 1 } else_circuit circuit (b) { 2 } else_circuit { 3 }", "circuit (a) {1;} else_circuit circuit (b) {2;} else_circuit {3;}"},
+		{"strategy (x) { case 1, 2..3: y\n z default: w }", "strategy (x) {case 1, 2..3: {y;z;}default: {w;}}"},
+		{"match (x) { 0 => a, -1.5..2 => b\n _ => c }", "match (x) {0 => a, -1.5..2 => b, _ => c};"},
+		{"match (x) { 1 => {\"a\": 1}, _ => {} }", "match (x) {1 => {\"a\": 1}, _ => {}};"},
+		{"match (x) { [a, [b], ...r] circuit (a > b) => r }", "match (x) {[a, [b], ...r] circuit ((a > b)) => r};"},
+		{"match (x) { {\"ALO\": n} => n, Car {number, driver: d} => d }", "match (x) {{\"ALO\": n} => n, Car {number: number, driver: d} => d};"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			p := NewParser(NewLexer(tt.input))
+			program := p.ParseProgram()
+
+			if errs := p.Errors(); len(errs) > 0 {
+				t.Fatalf("unexpected errors: %q", errs)
+			}
+			if got := program.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package alonso
+
+// Sandbox restricts what a program can reach, so that untrusted scripts can
+// run on the same runtime as trusted tooling. Interpreter and Machine embed
+// it; the zero value restricts nothing. It applies to the builtins every
+// program starts with, not to functions the host adds with Register.
+type Sandbox struct {
+	AllowedBuiltins []string // if non-nil, the only builtins a program may use
+	DeniedBuiltins  []string // builtins a program may not use
+	DisableImports  bool     // make every import statement an error
+}
+
+// SandboxProfile returns the sandbox `alonso --sandbox` runs programs in.
+// Imports are disabled and only builtins that compute or print are allowed,
+// so builtins that read input, files, the clock or processes stay out until
+// a host allows them by name.
+func SandboxProfile() Sandbox {
+	return Sandbox{
+		AllowedBuiltins: []string{
+			"telemetry", "length", "push", "keys", "values", "has_key", "delete",
+			"red_flag", "map", "filter", "reduce", "sort_by", "each",
+			"assert_eq", "assert_true", "assert_error",
+		},
+		DisableImports: true,
+	}
+}
+
+// Restrictions exposes the sandbox through the Backend interface.
+func (s *Sandbox) Restrictions() *Sandbox {
+	return s
+}
+
+// allows reports whether a program may use the builtin called name.
+func (s *Sandbox) allows(name string) bool {
+	for _, denied := range s.DeniedBuiltins {
+		if denied == name {
+			return false
+		}
+	}
+	if s.AllowedBuiltins == nil {
+		return true
+	}
+	for _, allowed := range s.AllowedBuiltins {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBuiltin returns an error if val is the builtin called name and the
+// sandbox does not allow it. Values bound over a builtin's name pass.
+func (s *Sandbox) checkBuiltin(name string, val Object) *Error {
+	if _, builtin := lookupBuiltin(name); builtin == nil || builtin != val || s.allows(name) {
+		return nil
+	}
+	return newError("`%s` is not available in this sandbox", name)
+}
@@ -0,0 +1,166 @@
+package alonso
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSandbox(t *testing.T) {
+	tests := []struct {
+		name    string
+		sandbox Sandbox
+		input   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "denied builtin",
+			sandbox: Sandbox{DeniedBuiltins: []string{"push"}},
+			input:   "telemetry(length([1]))\npush([], 1)",
+			want:    "1\n",
+			wantErr: "2:1: `push` is not available in this sandbox",
+		},
+		{
+			name:    "builtin outside the allowed list",
+			sandbox: Sandbox{AllowedBuiltins: []string{"telemetry"}},
+			input:   "telemetry(1)\ntelemetry(length([1]))",
+			want:    "1\n",
+			wantErr: "2:11: `length` is not available in this sandbox",
+		},
+		{
+			name:    "denied wins over allowed",
+			sandbox: Sandbox{AllowedBuiltins: []string{"telemetry", "keys"}, DeniedBuiltins: []string{"keys"}},
+			input:   "keys({})",
+			wantErr: "`keys` is not available in this sandbox",
+		},
+		{
+			name:    "builtins cannot be passed around",
+			sandbox: SandboxProfile(),
+			input:   `map(["Driver? "], radio)`,
+			wantErr: "`radio` is not available in this sandbox",
+		},
+		{
+			name:    "the error is catchable",
+			sandbox: SandboxProfile(),
+			input:   `safety_car { radio() } recover (err) { telemetry(err.message) }`,
+			want:    "`radio` is not available in this sandbox\n",
+		},
+		{
+			name:    "names bound over a builtin are the program's own",
+			sandbox: SandboxProfile(),
+			input:   "grid radio = \"box box\"\ntelemetry(radio)",
+			want:    "box box\n",
+		},
+		{
+			name:    "registered functions are not restricted",
+			sandbox: Sandbox{AllowedBuiltins: []string{}},
+			input:   `lap_time()`,
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		for _, backend := range backends(&out) {
+			t.Run(fmt.Sprintf("%s/%T", tt.name, backend), func(t *testing.T) {
+				out.Reset()
+				*backend.Restrictions() = tt.sandbox
+				if err := backend.Register("lap_time", func() float64 { return 90.8 }); err != nil {
+					t.Fatal(err)
+				}
+
+				err := backend.Execute(tt.input)
+				if tt.wantErr == "" && err != nil {
+					t.Fatalf("Execute: %v", err)
+				}
+				if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				if got := out.String(); got != tt.want {
+					t.Errorf("output = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestSandboxDisablesImports(t *testing.T) {
+	var out bytes.Buffer
+	for _, backend := range backends(&out) {
+		backend.Restrictions().DisableImports = true
+
+		err := backend.ExecuteFile("tests/test_import.alo", `import "lib/math.alo"`)
+		if err == nil || !strings.Contains(err.Error(), "imports are disabled in this sandbox") {
+			t.Errorf("%T error = %v", backend, err)
+		}
+	}
+}
+
+func TestOutputLimit(t *testing.T) {
+	var out bytes.Buffer
+	for _, backend := range backends(&out) {
+		out.Reset()
+		*backend.Resources() = Limits{MaxOutput: 12}
+
+		err := backend.Execute(`telemetry("Alonso")` + "\n" + `telemetry("Hamilton")` + "\n" + `telemetry("Verstappen")`)
+		if !errors.Is(err, ErrOutputLimit) || !strings.Contains(err.Error(), "2:1: output limit exceeded (12 bytes)") {
+			t.Errorf("%T error = %v, want %v", backend, err, ErrOutputLimit)
+		}
+		if got, want := out.String(), "Alonso\nHamil"; got != want {
+			t.Errorf("%T output = %q, want %q", backend, got, want)
+		}
+	}
+}
+
+// TestSandboxProfileContainsPrograms runs programs that once escaped the
+// profile: printing a value that contains itself crashed the host, and
+// growing a map went uncounted by the memory limit.
+func TestSandboxProfileContainsPrograms(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		err   error
+	}{
+		{
+			name:  "cyclic array",
+			input: "grid a = [1]\na[0] = a\ntelemetry(a)",
+			want:  "[[...]]\n",
+		},
+		{
+			name:  "cyclic struct",
+			input: "garage Node { value, next }\ngrid n = Node(1, 0)\nn.next = n\ntelemetry(n)",
+			want:  "Node{value: 1, next: Node{...}}\n",
+		},
+		{
+			name:  "cyclic map in interpolation",
+			input: "grid m = {}\nm[\"self\"] = m\ntelemetry(\"${m}\")",
+			want:  "{self: {...}}\n",
+		},
+		{
+			name:  "map growth",
+			input: "grid m = {}\ngrid i = 0\nwhile_racing (i < 2000000) { m[i] = i\ni++ }",
+			err:   ErrMemoryLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		for _, backend := range backends(&out) {
+			t.Run(fmt.Sprintf("%s/%T", tt.name, backend), func(t *testing.T) {
+				out.Reset()
+				*backend.Restrictions() = SandboxProfile()
+				*backend.Resources() = Limits{MaxSteps: 100_000_000, MaxMemory: 1 << 20}
+
+				if err := backend.Execute(tt.input); !errors.Is(err, tt.err) {
+					t.Fatalf("error = %v, want %v", err, tt.err)
+				}
+				if got := out.String(); got != tt.want {
+					t.Errorf("output = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package alonso
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// Streams are the standard input and output a program sees. Interpreter and
+// Machine embed them, so an embedding program can capture output or supply
+// input by replacing the fields.
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer // telemetry output
+	Stderr io.Writer // parser errors and other diagnostics
+
+	reader *bufio.Reader // buffers Stdin between reads
+	source io.Reader     // the Stdin that reader wraps
+}
+
+func newStreams() Streams {
+	return Streams{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+// IO exposes the streams through the Backend interface.
+func (s *Streams) IO() *Streams {
+	return s
+}
+
+// ReadLine reads the next line of Stdin without its line ending, returning
+// io.EOF once the input is exhausted. radio and the REPL share its buffer,
+// so neither loses input the other has read ahead.
+func (s *Streams) ReadLine() (string, error) {
+	if s.reader == nil || s.source != s.Stdin {
+		s.reader = bufio.NewReader(s.Stdin)
+		s.source = s.Stdin
+	}
+
+	line, err := s.reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
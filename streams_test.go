@@ -0,0 +1,66 @@
+package alonso
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStreams(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		stdin      string
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "telemetry writes to stdout",
+			input:      `telemetry("box", 14)`,
+			wantStdout: "box 14\n",
+		},
+		{
+			name:       "radio reads lines",
+			input:      "grid a = radio()\ngrid b = radio()\ntelemetry(b, a)",
+			stdin:      "Alonso\r\nHamilton\n",
+			wantStdout: "Hamilton Alonso\n",
+		},
+		{
+			name:       "radio prints its prompt",
+			input:      `telemetry(radio("Driver? "))`,
+			stdin:      "Alonso",
+			wantStdout: "Driver? Alonso\n",
+		},
+		{
+			name:       "radio returns null at end of input",
+			input:      `grid a = radio()` + "\n" + `telemetry(radio() == a, length(a))`,
+			stdin:      "\n",
+			wantStdout: "false 0\n",
+		},
+		{
+			name:       "parser errors go to stderr",
+			input:      "grid x 5",
+			wantStderr: "Parser error: <input>:1:8: expected next token to be ASSIGN, got NUMBER instead\n",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, backend := range []Backend{NewInterpreter(), NewMachine()} {
+			t.Run(tt.name, func(t *testing.T) {
+				var stdout, stderr bytes.Buffer
+				backend.IO().Stdin = strings.NewReader(tt.stdin)
+				backend.IO().Stdout = &stdout
+				backend.IO().Stderr = &stderr
+
+				backend.Execute(tt.input)
+
+				if got := stdout.String(); got != tt.wantStdout {
+					t.Errorf("%T stdout = %q, want %q", backend, got, tt.wantStdout)
+				}
+				if got := stderr.String(); got != tt.wantStderr {
+					t.Errorf("%T stderr = %q, want %q", backend, got, tt.wantStderr)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package alonso
+
+type SymbolScope string
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"
+	LocalScope   SymbolScope = "LOCAL"
+	OuterScope   SymbolScope = "OUTER"
+	BuiltinScope SymbolScope = "BUILTIN"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+	Depth int // number of enclosing functions to walk for OuterScope
+}
+
+// slotCounter hands out storage slots. A function and every block nested
+// inside it share one counter, since they share one frame at runtime.
+type slotCounter struct {
+	names []string
+}
+
+// SymbolTable mirrors the environments the tree-walking interpreter creates:
+// one per function call, `loop` statement and block. Functions, blocks and
+// loop-in passes each get their own slots, kept in a frame or in a scope the
+// VM opens at runtime. Block tables, used for the header of a `loop` and the
+// hidden values of strategy, match and safety_car, allocate slots from the
+// table enclosing them.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store   map[string]Symbol
+	pending map[string]bool // declared ahead of their grid or pace
+	slots   *slotCounter
+	block   bool
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		store:   make(map[string]Symbol),
+		pending: make(map[string]bool),
+		slots:   &slotCounter{},
+	}
+}
+
+// NewFunctionSymbolTable opens a scope with its own slots: a frame, or a
+// scope opened by OpEnterScope.
+func NewFunctionSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// NewBlockSymbolTable opens a scope that stores into its outer frame.
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{
+		Outer:   outer,
+		store:   make(map[string]Symbol),
+		pending: make(map[string]bool),
+		slots:   outer.slots,
+		block:   true,
+	}
+}
+
+// Root returns the table of the top-level scope.
+func (s *SymbolTable) Root() *SymbolTable {
+	t := s
+	for t.Outer != nil {
+		t = t.Outer
+	}
+	return t
+}
+
+func (s *SymbolTable) isGlobal() bool {
+	t := s
+	for t.block {
+		t = t.Outer
+	}
+	return t.Outer == nil
+}
+
+// Define binds name in this scope, reusing the slot if it is already bound
+// here. Like Environment.Set, it never touches an enclosing scope.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		delete(s.pending, name)
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: len(s.slots.names)}
+	if s.isGlobal() {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.slots.names = append(s.slots.names, name)
+	s.store[name] = symbol
+	return symbol
+}
+
+// Declare binds name in this scope ahead of the grid or pace that defines
+// it, so that paces nested in the scope can refer to names declared after
+// them, as they can in the interpreter, which looks names up when a pace
+// runs. Until Define is called, code running in the scope's own frame
+// still resolves name to any outer binding.
+func (s *SymbolTable) Declare(name string) {
+	if _, ok := s.store[name]; !ok {
+		s.Define(name)
+		s.pending[name] = true
+	}
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	depth := 0
+	for t := s; t != nil; t = t.Outer {
+		if symbol, ok := t.store[name]; ok && (depth > 0 || !t.pending[name]) {
+			if symbol.Scope == LocalScope && depth > 0 {
+				symbol.Scope = OuterScope
+				symbol.Depth = depth
+			}
+			return symbol, true
+		}
+		if !t.block {
+			depth++
+		}
+	}
+
+	if idx, _ := lookupBuiltin(name); idx >= 0 {
+		return Symbol{Name: name, Scope: BuiltinScope, Index: idx}, true
+	}
+
+	return Symbol{}, false
+}
+
+func (s *SymbolTable) NumSlots() int {
+	return len(s.slots.names)
+}
+
+func (s *SymbolTable) SlotNames() []string {
+	return s.slots.names
+}
@@ -0,0 +1,884 @@
+package alonso
+
+import (
+	"context"
+	"fmt"
+)
+
+// Initial sizes of the value stack and the call stack. Both grow as needed;
+// Limits.MaxDepth bounds how deep calls may go.
+const (
+	StackSize = 2048
+	MaxFrames = 1024
+)
+
+var opcodeOperators = map[Opcode]string{
+	OpAdd:          "+",
+	OpSub:          "-",
+	OpMul:          "*",
+	OpDiv:          "/",
+	OpMod:          "%",
+	OpEqual:        "==",
+	OpNotEqual:     "!=",
+	OpLess:         "<",
+	OpLessEqual:    "<=",
+	OpGreater:      ">",
+	OpGreaterEqual: ">=",
+	OpAnd:          "&&",
+	OpOr:           "||",
+}
+
+type Frame struct {
+	cl          *Closure
+	ip          int
+	basePointer int
+	scope       *Scope
+}
+
+func NewFrame(cl *Closure, basePointer int, scope *Scope) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer, scope: scope}
+}
+
+func (f *Frame) Instructions() Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// handler records an active safety_car: the frame, scope and stack height
+// to unwind to, and where its recover clause begins.
+type handler struct {
+	framesIndex int
+	scope       *Scope
+	sp          int
+	ip          int
+}
+
+type VM struct {
+	constants []Object
+	globals   []Object
+	symbols   *SymbolTable
+
+	stack []Object
+	sp    int // always points to the next free slot
+
+	frames      []*Frame
+	framesIndex int
+
+	handlers []handler
+	host     *Host    // handed to builtins
+	meter    *meter   // resources used by this run
+	sandbox  *Sandbox // builtins the program may use
+	machine  *Machine // loads the modules the program imports
+}
+
+// NewVM prepares bytecode for execution. The globals slice is reused and
+// grown as needed, so a REPL can carry state from one run to the next.
+func NewVM(bytecode *Bytecode, symbols *SymbolTable, globals []Object, streams *Streams) *VM {
+	for len(globals) < symbols.NumSlots() {
+		globals = append(globals, nil)
+	}
+
+	mainFn := &CompiledFunction{Instructions: bytecode.Instructions, Positions: bytecode.Positions, File: bytecode.File}
+	mainFrame := NewFrame(&Closure{Fn: mainFn}, 0, nil)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	vm := &VM{
+		constants:   bytecode.Constants,
+		globals:     globals,
+		symbols:     symbols,
+		stack:       make([]Object, StackSize),
+		frames:      frames,
+		framesIndex: 1,
+		sandbox:     &Sandbox{},
+	}
+	vm.host = &Host{Streams: streams, Call: vm.callValue}
+	vm.setMeter(newMeter(context.Background(), Limits{}))
+	return vm
+}
+
+// setMeter makes the run account its resources to m.
+func (vm *VM) setMeter(m *meter) {
+	vm.meter = m
+	vm.host.meter = m
+}
+
+func (vm *VM) Globals() []Object {
+	return vm.globals
+}
+
+func (vm *VM) Run() *Error {
+	return vm.run(0)
+}
+
+// run executes instructions until the frames above depth have returned.
+// Builtins that call back into closures start a nested run.
+func (vm *VM) run(depth int) *Error {
+	for vm.framesIndex > depth && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		frame := vm.currentFrame()
+		frame.ip++
+
+		ip := frame.ip
+		ins := frame.Instructions()
+		op := Opcode(ins[ip])
+
+		if err := vm.meter.step(); err != nil {
+			if err = vm.handleError(err, frame, ip, depth); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var err *Error
+
+		switch op {
+		case OpConstant:
+			constIndex := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = vm.push(vm.constants[constIndex])
+
+		case OpPop:
+			vm.pop()
+
+		case OpDup:
+			n := int(ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+			for k := 0; k < n && err == nil; k++ {
+				err = vm.push(vm.stack[vm.sp-n])
+			}
+
+		case OpNull:
+			err = vm.push(NULL)
+
+		case OpTrue:
+			err = vm.push(TRUE)
+
+		case OpFalse:
+			err = vm.push(FALSE)
+
+		case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpEqual, OpNotEqual,
+			OpLess, OpLessEqual, OpGreater, OpGreaterEqual, OpAnd, OpOr:
+			err = vm.executeBinaryOperation(op)
+
+		case OpMinus:
+			err = vm.pushResult(evalPrefixExpression("-", vm.pop()))
+
+		case OpBang:
+			err = vm.push(evalBangOperatorExpression(vm.pop()))
+
+		case OpJump:
+			pos := int(ReadUint16(ins[ip+1:]))
+			frame.ip = pos - 1
+
+		case OpJumpNotTruthy:
+			pos := int(ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			if !isTruthy(vm.pop()) {
+				frame.ip = pos - 1
+			}
+
+		case OpPushHandler:
+			pos := int(ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			vm.handlers = append(vm.handlers, handler{framesIndex: vm.framesIndex, scope: frame.scope, sp: vm.sp, ip: pos})
+
+		case OpPopHandler:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case OpGetGlobal:
+			index := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			if val := vm.globals[index]; val != nil {
+				err = vm.push(val)
+			} else {
+				err = newError("identifier not found: " + vm.symbols.SlotNames()[index])
+			}
+
+		case OpSetGlobal:
+			index := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.globals[index] = vm.pop()
+
+		case OpGetLocal:
+			index := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = vm.pushSlot(frame.scope, int(index))
+
+		case OpSetLocal:
+			index := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			frame.scope.Slots[index] = vm.pop()
+
+		case OpGetOuter:
+			depth := int(ReadUint8(ins[ip+1:]))
+			index := ReadUint16(ins[ip+2:])
+			frame.ip += 3
+			scope := frame.scope
+			for d := 0; d < depth; d++ {
+				scope = scope.Outer
+			}
+			err = vm.pushSlot(scope, int(index))
+
+		case OpSetOuter:
+			depth := int(ReadUint8(ins[ip+1:]))
+			index := ReadUint16(ins[ip+2:])
+			frame.ip += 3
+			scope := frame.scope
+			for d := 0; d < depth; d++ {
+				scope = scope.Outer
+			}
+			scope.Slots[index] = vm.pop()
+
+		case OpGetBuiltin:
+			index := ReadUint8(ins[ip+1:])
+			frame.ip += 1
+			def := builtins[index]
+			if err = vm.sandbox.checkBuiltin(def.Name, def.Builtin); err == nil {
+				err = vm.push(def.Builtin)
+			}
+
+		case OpGetName:
+			constIndex := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = vm.pushName(vm.constants[constIndex].(*String).Value)
+
+		case OpSetName:
+			constIndex := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = vm.setName(vm.constants[constIndex].(*String).Value, vm.pop())
+
+		case OpArray:
+			numElements := int(ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			elements := make([]Object, numElements)
+			copy(elements, vm.stack[vm.sp-numElements:vm.sp])
+			vm.sp -= numElements
+			err = vm.pushResult(vm.meter.charge(&Array{Elements: elements}))
+
+		case OpMap:
+			numPairs := int(ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			err = vm.buildMap(numPairs)
+
+		case OpInterpolate:
+			numParts := int(ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			parts := make([]Object, numParts)
+			copy(parts, vm.stack[vm.sp-numParts:vm.sp])
+			vm.sp -= numParts
+			err = vm.pushResult(vm.meter.charge(interpolate(parts)))
+
+		case OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evalIndexExpression(left, index))
+
+		case OpIterate:
+			withKeys := ReadUint8(ins[ip+1:]) == 1
+			frame.ip += 1
+			err = vm.pushResult(newIterator(vm.pop(), withKeys))
+
+		case OpRange:
+			end := vm.pop()
+			start := vm.pop()
+			err = vm.pushResult(newRangeIterator(start, end))
+
+		case OpInRange:
+			end := vm.pop()
+			start := vm.pop()
+			err = vm.pushResult(inCaseRange(vm.pop(), start, end))
+
+		case OpMatch:
+			constIndex := int(ReadUint16(ins[ip+1:]))
+			pos := int(ReadUint16(ins[ip+3:]))
+			frame.ip += 4
+			pattern := vm.constants[constIndex].(*MatchPattern)
+			var garages map[*StructPattern]*Garage
+			if garages, err = vm.patternGarages(pattern); err != nil {
+				break
+			}
+			bound, ok := matchPattern(pattern.Pattern, vm.pop(), garages)
+			if !ok {
+				frame.ip = pos - 1
+			}
+			for _, value := range bound {
+				if err = vm.push(value); err != nil {
+					break
+				}
+			}
+
+		case OpNoMatch:
+			err = noMatchError(vm.pop())
+
+		case OpIterNext:
+			pos := int(ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			key, value, ok := vm.pop().(*Iterator).Next()
+			if !ok {
+				frame.ip = pos - 1
+			} else if err = vm.push(key); err == nil {
+				err = vm.push(value)
+			}
+
+		case OpEnterScope:
+			constIndex := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			layout := vm.constants[constIndex].(*ScopeLayout)
+			frame.scope = &Scope{
+				Slots: make([]Object, len(layout.SlotNames)),
+				Names: layout.SlotNames,
+				Outer: frame.scope,
+			}
+
+		case OpLeaveScope:
+			frame.scope = frame.scope.Outer
+
+		case OpSlice:
+			end := vm.pop()
+			start := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(vm.meter.charge(evalSliceExpression(left, start, end)))
+
+		case OpSetIndex:
+			val := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(vm.meter.setIndex(left, index, val))
+
+		case OpGetField:
+			constIndex := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			field := vm.constants[constIndex].(*String).Value
+			err = vm.pushResult(evalMemberExpression(vm.pop(), field))
+
+		case OpSetField:
+			constIndex := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			field := vm.constants[constIndex].(*String).Value
+			val := vm.pop()
+			object := vm.pop()
+			err = vm.pushResult(evalFieldAssignment(object, field, val))
+
+		case OpImport:
+			constIndex := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			path := vm.constants[constIndex].(*String).Value
+			err = vm.pushResult(vm.importModule(frame.cl.Fn.File, path))
+
+		case OpClosure:
+			constIndex := ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			fn := vm.constants[constIndex].(*CompiledFunction)
+			err = vm.push(&Closure{Fn: fn, Env: frame.scope})
+
+		case OpCall:
+			numArgs := int(ReadUint8(ins[ip+1:]))
+			frame.ip += 1
+			err = vm.executeCall(numArgs)
+
+		case OpReturnValue, OpReturn:
+			var returnValue Object = NULL
+			if op == OpReturnValue {
+				returnValue = vm.pop()
+			}
+
+			if vm.framesIndex == 1 {
+				// return_pit at the top level ends the program
+				return nil
+			}
+
+			// Handlers set up in the returning frame no longer apply.
+			for n := len(vm.handlers); n > 0 && vm.handlers[n-1].framesIndex == vm.framesIndex; n-- {
+				vm.handlers = vm.handlers[:n-1]
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			err = vm.push(returnValue)
+
+		default:
+			err = newError("unknown opcode: %d", op)
+		}
+
+		if err != nil {
+			if err = vm.handleError(err, frame, ip, depth); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// importModule loads the module at path for code compiled from the file
+// from. The module's code is compiled into the machine's constants, which
+// this VM then picks up, since paces the module exports run here too.
+func (vm *VM) importModule(from, path string) Object {
+	if vm.machine == nil {
+		return newError("imports are not available here")
+	}
+	result := vm.machine.importModule(vm, from, path)
+	vm.constants = vm.machine.constants
+	return result
+}
+
+// patternGarages pops the garages the struct patterns of pattern name,
+// which the compiler pushes after the value being matched.
+func (vm *VM) patternGarages(pattern *MatchPattern) (map[*StructPattern]*Garage, *Error) {
+	n := len(pattern.Structs)
+	vals := vm.stack[vm.sp-n : vm.sp]
+	vm.sp -= n
+
+	var garages map[*StructPattern]*Garage
+	for idx, sp := range pattern.Structs {
+		garage, err := patternGarage(sp, vals[idx])
+		if err != nil {
+			return nil, err
+		}
+		if garages == nil {
+			garages = make(map[*StructPattern]*Garage)
+		}
+		garages[sp] = garage
+	}
+	return garages, nil
+}
+
+// handleError locates err at the instruction ip of frame and recovers it if
+// a safety_car of this run is active. It returns the error if not.
+func (vm *VM) handleError(err *Error, frame *Frame, ip int, depth int) *Error {
+	if err.Pos.Line == 0 {
+		err.Pos = frame.cl.Fn.Positions.Lookup(ip)
+		err.File = frame.cl.Fn.File
+	}
+	if n := len(vm.handlers); n > 0 && vm.handlers[n-1].framesIndex > depth {
+		if err = vm.recover(err); err == nil {
+			return nil
+		}
+	}
+	if err.Trace == nil {
+		err.Trace = vm.traceback()
+	}
+	return err
+}
+
+// recover unwinds to the innermost safety_car and enters its recover clause
+// with the error as an incident.
+func (vm *VM) recover(err *Error) *Error {
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.framesIndex = h.framesIndex
+	vm.sp = h.sp
+	vm.currentFrame().scope = h.scope
+	vm.currentFrame().ip = h.ip - 1
+
+	return vm.push(newIncident(err))
+}
+
+// traceback lists the call sites of the active frames, outermost first.
+func (vm *VM) traceback() []TraceFrame {
+	var trace []TraceFrame
+	for _, caller := range vm.frames[:vm.framesIndex-1] {
+		name := caller.cl.Fn.Name
+		if caller == vm.frames[0] {
+			name = "<main>"
+		}
+		trace = append(trace, TraceFrame{
+			Function: name,
+			File:     caller.cl.Fn.File,
+			Pos:      caller.cl.Fn.Positions.Lookup(caller.ip),
+		})
+	}
+	return trace
+}
+
+func (vm *VM) executeBinaryOperation(op Opcode) *Error {
+	right := vm.pop()
+	left := vm.pop()
+
+	// Fast path for the common integer cases; everything else shares the
+	// interpreter's semantics.
+	if l, ok := left.(*Integer); ok {
+		if r, ok := right.(*Integer); ok {
+			switch op {
+			case OpAdd, OpSub, OpMul, OpMod:
+				return vm.pushResult(evalIntegerInfixExpression(opcodeOperators[op], l.Value, r.Value))
+			case OpLess:
+				return vm.push(nativeBoolToBooleanObject(l.Value < r.Value))
+			case OpLessEqual:
+				return vm.push(nativeBoolToBooleanObject(l.Value <= r.Value))
+			case OpGreater:
+				return vm.push(nativeBoolToBooleanObject(l.Value > r.Value))
+			case OpGreaterEqual:
+				return vm.push(nativeBoolToBooleanObject(l.Value >= r.Value))
+			case OpEqual:
+				return vm.push(nativeBoolToBooleanObject(l.Value == r.Value))
+			case OpNotEqual:
+				return vm.push(nativeBoolToBooleanObject(l.Value != r.Value))
+			}
+		}
+	}
+
+	return vm.pushResult(vm.meter.charge(evalInfixExpression(opcodeOperators[op], left, right)))
+}
+
+func (vm *VM) executeCall(numArgs int) *Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch callee := callee.(type) {
+	case *Closure:
+		return vm.callClosure(callee, numArgs)
+	case *Builtin:
+		args := make([]Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp = vm.sp - numArgs - 1
+		return vm.pushResult(vm.meter.charge(callee.Call(vm.host, args)))
+	case *Garage:
+		args := make([]Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		vm.sp = vm.sp - numArgs - 1
+		return vm.pushResult(vm.meter.charge(newStruct(callee, args)))
+	default:
+		return newError("not a function: %s", callee.Type())
+	}
+}
+
+// callValue calls fn from Go, running a closure to completion before
+// returning its result. It is the Caller the VM hands to builtins.
+func (vm *VM) callValue(fn Object, args ...Object) Object {
+	switch fn := fn.(type) {
+	case *Closure:
+		depth := vm.framesIndex
+		if err := vm.push(fn); err != nil {
+			return err
+		}
+		for _, arg := range args {
+			if err := vm.push(arg); err != nil {
+				return err
+			}
+		}
+		if err := vm.callClosure(fn, len(args)); err != nil {
+			return err
+		}
+		if err := vm.run(depth); err != nil {
+			return err
+		}
+		return vm.pop()
+	case *Builtin:
+		return vm.meter.charge(fn.Call(vm.host, args))
+	case *Garage:
+		return vm.meter.charge(newStruct(fn, args))
+	default:
+		return newError("not a function: %s", fn.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *Closure, numArgs int) *Error {
+	if err := vm.meter.enter(vm.framesIndex - 1); err != nil {
+		return err
+	}
+
+	fn := cl.Fn
+	scope := &Scope{
+		Slots: make([]Object, len(fn.SlotNames)),
+		Names: fn.SlotNames,
+		Outer: cl.Env,
+	}
+
+	// Missing arguments stay unbound and extra ones are dropped, as in
+	// Interpreter.extendFunctionEnv.
+	basePointer := vm.sp - numArgs
+	for i := 0; i < fn.NumParameters && i < numArgs; i++ {
+		scope.Slots[i] = vm.stack[basePointer+i]
+	}
+
+	vm.sp = basePointer
+	vm.pushFrame(NewFrame(cl, basePointer, scope))
+	return nil
+}
+
+func (vm *VM) buildMap(numPairs int) *Error {
+	start := vm.sp - numPairs*2
+	m := NewMap()
+
+	for i := start; i < vm.sp; i += 2 {
+		key, ok := vm.stack[i].(Hashable)
+		if !ok {
+			return newError("unusable as map key: %s", vm.stack[i].Type())
+		}
+		m.Set(key, vm.stack[i+1])
+	}
+
+	vm.sp = start
+	return vm.pushResult(vm.meter.charge(m))
+}
+
+func (vm *VM) pushSlot(scope *Scope, index int) *Error {
+	val := scope.Slots[index]
+	if val == nil {
+		return newError("identifier not found: " + scope.Names[index])
+	}
+	return vm.push(val)
+}
+
+// setName assigns a global the compiler could not resolve, which must have
+// been declared by the time the assignment runs.
+func (vm *VM) setName(name string, val Object) *Error {
+	if symbol, ok := vm.symbols.store[name]; ok && vm.globals[symbol.Index] != nil {
+		vm.globals[symbol.Index] = val
+		return nil
+	}
+	return newError("assignment to undeclared variable %s", name)
+}
+
+func (vm *VM) pushName(name string) *Error {
+	if symbol, ok := vm.symbols.store[name]; ok {
+		if val := vm.globals[symbol.Index]; val != nil {
+			return vm.push(val)
+		}
+	}
+	return newError("identifier not found: " + name)
+}
+
+// pushResult pushes the outcome of a shared evaluation helper, turning an
+// *Error result into a runtime error.
+func (vm *VM) pushResult(result Object) *Error {
+	if err, ok := result.(*Error); ok {
+		return err
+	}
+	if result == nil {
+		result = NULL
+	}
+	return vm.push(result)
+}
+
+func (vm *VM) push(o Object) *Error {
+	if vm.sp >= len(vm.stack) {
+		vm.stack = append(vm.stack, make([]Object, len(vm.stack))...)
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+	return nil
+}
+
+func (vm *VM) pop() Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	if vm.framesIndex >= len(vm.frames) {
+		vm.frames = append(vm.frames, make([]*Frame, len(vm.frames))...)
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// Machine runs source code on the VM, keeping globals between calls to
+// Execute. It is the bytecode counterpart of Interpreter.
+type Machine struct {
+	Streams
+	Limits
+	Sandbox
+
+	file      string
+	sources   map[string]string // program text by file, for error reports
+	modules   *moduleLoader     // imported modules
+	symbols   *SymbolTable
+	constants []Object
+	globals   []Object
+}
+
+func NewMachine() *Machine {
+	return &Machine{
+		Streams:   newStreams(),
+		sources:   make(map[string]string),
+		modules:   newModuleLoader(),
+		symbols:   NewSymbolTable(),
+		constants: []Object{},
+	}
+}
+
+// ExecuteFile runs input as the contents of filename, so that imports in it
+// resolve relative to that file.
+func (m *Machine) ExecuteFile(filename, input string) error {
+	return m.ExecuteFileContext(context.Background(), filename, input)
+}
+
+// ExecuteFileContext is ExecuteFile, stopping with a runtime error once ctx
+// is done.
+func (m *Machine) ExecuteFileContext(ctx context.Context, filename, input string) error {
+	previous := m.file
+	m.file = filename
+	defer func() { m.file = previous }()
+
+	return m.ExecuteContext(ctx, input)
+}
+
+func (m *Machine) Execute(input string) error {
+	return m.ExecuteContext(context.Background(), input)
+}
+
+// ExecuteContext is Execute, stopping with a runtime error once ctx is done.
+func (m *Machine) ExecuteContext(ctx context.Context, input string) error {
+	lexer := NewLexer(input)
+	parser := NewParser(lexer)
+	program := parser.ParseProgram()
+
+	if len(parser.Errors()) > 0 {
+		err := newParseError(m.file, parser)
+		err.Report(m.Stderr)
+		return err
+	}
+
+	compiler := NewCompilerWithState(m.symbols, m.constants)
+	compiler.file = m.file
+	if err := compiler.Compile(program); err != nil {
+		return fmt.Errorf("compilation failed: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	m.constants = bytecode.Constants
+	m.sources[m.file] = input
+
+	vm := NewVM(bytecode, m.symbols, m.globals, &m.Streams)
+	vm.setMeter(newMeter(ctx, m.Limits))
+	vm.sandbox = &m.Sandbox
+	vm.machine = m
+	runErr := vm.Run()
+	m.globals = vm.Globals()
+
+	if runErr != nil {
+		return newRuntimeError(runErr, m.sources)
+	}
+
+	return nil
+}
+
+// importModule runs the module at path, imported from the file from, for
+// the VM parent. Each module runs once, on a VM of its own that shares the
+// parent's globals, so that it sees the functions the host registers, and
+// the parent's resources, so that the limits cover the whole program.
+func (m *Machine) importModule(parent *VM, from, path string) Object {
+	if m.DisableImports {
+		return newError("imports are disabled in this sandbox")
+	}
+
+	return m.modules.load(from, path, m.sources, func(file string, program *Program) Object {
+		compiler := NewCompilerWithState(NewSymbolTable(), m.constants)
+		compiler.file = file
+		module, err := compiler.compileModule(program)
+		if err != nil {
+			return newError("cannot import %q: %s", path, err)
+		}
+
+		bytecode := compiler.Bytecode()
+		m.constants = bytecode.Constants
+
+		vm := NewVM(bytecode, m.symbols, parent.globals, &m.Streams)
+		vm.frames[0].scope = module.scope
+		vm.setMeter(parent.meter)
+		vm.sandbox = parent.sandbox
+		vm.machine = m
+		if err := vm.Run(); err != nil {
+			return err
+		}
+		return module
+	})
+}
+
+// Call calls the global function name with args, as Interpreter.Call does.
+func (m *Machine) Call(name string, args ...interface{}) (Object, error) {
+	return m.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is Call, stopping with a runtime error once ctx is done.
+func (m *Machine) CallContext(ctx context.Context, name string, args ...interface{}) (Object, error) {
+	fn, ok := m.Global(name)
+	if !ok {
+		return nil, fmt.Errorf("identifier not found: %s", name)
+	}
+
+	objects, err := toObjects(args)
+	if err != nil {
+		return nil, fmt.Errorf("calling %s: %s", name, err)
+	}
+
+	vm := NewVM(&Bytecode{Constants: m.constants}, m.symbols, m.globals, &m.Streams)
+	vm.setMeter(newMeter(ctx, m.Limits))
+	vm.sandbox = &m.Sandbox
+	vm.machine = m
+	result := vm.callValue(fn, objects...)
+	m.globals = vm.Globals()
+
+	if err, ok := result.(*Error); ok {
+		return nil, newRuntimeError(err, m.sources)
+	}
+	return result, nil
+}
+
+// Register makes fn callable from Alonso code as name. See NewBuiltin for
+// how values are converted.
+func (m *Machine) Register(name string, fn interface{}) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+
+	builtin, err := NewBuiltin(name, fn)
+	if err != nil {
+		return err
+	}
+
+	m.setGlobal(name, builtin)
+	return nil
+}
+
+// SetGlobal binds name to value as a global variable.
+func (m *Machine) SetGlobal(name string, value interface{}) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid name %q", name)
+	}
+
+	obj, err := ToObject(value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	m.setGlobal(name, obj)
+	return nil
+}
+
+func (m *Machine) setGlobal(name string, obj Object) {
+	symbol := m.symbols.Define(name)
+	for len(m.globals) <= symbol.Index {
+		m.globals = append(m.globals, nil)
+	}
+	m.globals[symbol.Index] = obj
+}
+
+// Global returns the value of the global name, or of the builtin of that
+// name if no global shadows it.
+func (m *Machine) Global(name string) (Object, bool) {
+	if symbol, ok := m.symbols.store[name]; ok {
+		if symbol.Index < len(m.globals) && m.globals[symbol.Index] != nil {
+			return m.globals[symbol.Index], true
+		}
+		return nil, false
+	}
+
+	if _, builtin := lookupBuiltin(name); builtin != nil {
+		return builtin, true
+	}
+	return nil, false
+}